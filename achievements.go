@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Achievement names and descriptions live in the locale catalogs under
+// achievement.<key>.name and achievement.<key>.description.
+type Achievement struct {
+	Key   string
+	Emoji string
+}
+
+func (a Achievement) Name(locale discordgo.Locale) string {
+	return tr(locale, "achievement."+a.Key+".name")
+}
+
+func (a Achievement) Description(locale discordgo.Locale) string {
+	return tr(locale, "achievement."+a.Key+".description")
+}
+
+type achievementStats struct {
+	TotalCommits int
+	TrackedRepos int
+	Commits      []PushCommit
+}
+
+var achievements = []struct {
+	Achievement
+	unlocked func(achievementStats) bool
+}{
+	{
+		Achievement{"first_commit", "🌱"},
+		func(st achievementStats) bool { return st.TotalCommits >= 1 },
+	},
+	{
+		Achievement{"ten_repos", "📚"},
+		func(st achievementStats) bool { return st.TrackedRepos >= 10 },
+	},
+	{
+		Achievement{"weekend_warrior", "⚔️"},
+		func(st achievementStats) bool {
+			return anyCommitAt(st.Commits, func(t time.Time) bool {
+				return t.Weekday() == time.Saturday || t.Weekday() == time.Sunday
+			})
+		},
+	},
+	{
+		Achievement{"night_owl", "🦉"},
+		func(st achievementStats) bool {
+			return anyCommitAt(st.Commits, func(t time.Time) bool { return t.Hour() < 4 })
+		},
+	},
+	{
+		Achievement{"thousand_commits", "🏆"},
+		func(st achievementStats) bool { return st.TotalCommits >= 1000 },
+	},
+}
+
+// anyCommitAt checks commit timestamps in the author's own timezone, as
+// reported in the push payload, so "night" means night for the committer.
+func anyCommitAt(commits []PushCommit, match func(time.Time) bool) bool {
+	for _, c := range commits {
+		t, err := time.Parse(time.RFC3339, c.Timestamp)
+		if err != nil {
+			continue
+		}
+		if match(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateAchievements only credits the user with commits made by their
+// linked GitHub account, so members of a shared repo don't earn badges for
+// each other's work.
+func evaluateAchievements(db Store, userID string, commits []PushCommit) ([]Achievement, error) {
+	totalCommits, trackedRepos, err := db.GetUserCommitStats(userID)
+	if err != nil {
+		return nil, err
+	}
+	account, err := db.GetUserAccount(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var own []PushCommit
+	for _, c := range commits {
+		if account.GithubLogin != "" && strings.EqualFold(c.Author.Username, account.GithubLogin) {
+			own = append(own, c)
+		}
+	}
+	stats := achievementStats{TotalCommits: totalCommits, TrackedRepos: trackedRepos, Commits: own}
+
+	var unlocked []Achievement
+	for _, a := range achievements {
+		if !a.unlocked(stats) {
+			continue
+		}
+		isNew, err := db.UnlockAchievement(userID, a.Key)
+		if err != nil {
+			return unlocked, err
+		}
+		if isNew {
+			unlocked = append(unlocked, a.Achievement)
+		}
+	}
+	return unlocked, nil
+}
+
+func announceAchievements(db Store, dg *discordgo.Session, userID, guildID, channelID string, commits []PushCommit) {
+	unlocked, err := evaluateAchievements(db, userID, commits)
+	if err != nil {
+		log.Printf("Error evaluating achievements for user %s: %v", userID, err)
+	}
+
+	if len(unlocked) == 0 {
+		return
+	}
+
+	locale := guildLocale(db, dg, guildID)
+	for _, a := range unlocked {
+		sendMessage(dg, channelID, renderMessage(db, dg, guildID, "achievement_unlocked", MessageData{
+			User:        fmt.Sprintf("<@%s>", userID),
+			Name:        a.Name(locale),
+			Emoji:       a.Emoji,
+			Description: a.Description(locale),
+		}))
+	}
+}
+
+func handleBadgesCommand(ctx *CommandContext) error {
+	userID := ctx.User.ID
+	if user := ctx.UserOption("user"); user != nil {
+		userID = user.ID
+	}
+
+	unlocked, err := ctx.DB.GetUserAchievements(userID)
+	if err != nil {
+		return ctx.Errorf("badges.load_error", err)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(tr(ctx.Locale, "badges.header", userID, len(unlocked), len(achievements)) + "\n")
+	for _, a := range achievements {
+		if at, ok := unlocked[a.Key]; ok {
+			sb.WriteString(tr(ctx.Locale, "badges.unlocked", a.Emoji, a.Name(ctx.Locale), a.Description(ctx.Locale), at.Format(time.DateOnly)) + "\n")
+		} else {
+			sb.WriteString(tr(ctx.Locale, "badges.locked", a.Name(ctx.Locale), a.Description(ctx.Locale)) + "\n")
+		}
+	}
+	return ctx.Reply(sb.String())
+}
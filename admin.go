@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// isGuildAdmin allows members with Manage Server (or Administrator), or the
+// guild's configured admin role. DefaultMemberPermissions only hides the
+// command by default, so the check is repeated here.
+func isGuildAdmin(ctx *CommandContext) bool {
+	member := ctx.Interaction.Member
+	if member == nil {
+		return false
+	}
+	if member.Permissions&(discordgo.PermissionManageGuild|discordgo.PermissionAdministrator) != 0 {
+		return true
+	}
+
+	roleID, err := ctx.DB.GetGuildAdminRole(ctx.GuildID)
+	if err != nil {
+		log.Printf("Error getting admin role for guild %s: %v", ctx.GuildID, err)
+		return false
+	}
+	return roleID != "" && slices.Contains(member.Roles, roleID)
+}
+
+func auditAdminAction(ctx *CommandContext, action, details string) {
+	if err := ctx.DB.LogAdminAction(ctx.GuildID, ctx.User.ID, action, details); err != nil {
+		log.Printf("Error writing audit log for guild %s: %v", ctx.GuildID, err)
+	}
+}
+
+// autocompleteAdminRepos suggests the repos the selected member registered
+// in this guild for /admin unregister.
+func autocompleteAdminRepos(ctx *CommandContext) []*discordgo.ApplicationCommandOptionChoice {
+	name, query := ctx.Focused()
+	if ctx.Subcommand != "unregister" || name != "repo" || !isGuildAdmin(ctx) {
+		return nil
+	}
+
+	userID := ctx.OptionID("user")
+	if userID == "" {
+		return nil
+	}
+	return registeredRepoChoices(ctx.DB, userID, ctx.GuildID, query)
+}
+
+func handleAdminCommand(ctx *CommandContext) error {
+	if !isGuildAdmin(ctx) {
+		return ctx.Errorf("admin.forbidden")
+	}
+
+	switch ctx.Subcommand {
+	case "unregister":
+		target := ctx.UserOption("user")
+		owner, repo, ok := parseRepoInput(ctx.StringOption("repo"))
+		if !ok {
+			return ctx.Errorf("repo.invalid_format")
+		}
+
+		if err := removeRegistration(ctx.DB, ctx.Session, target.ID, owner, repo, ctx.GuildID); err != nil {
+			return ctx.Errorf("unregister.error", err)
+		}
+		auditAdminAction(ctx, "unregister", fmt.Sprintf("%s %s/%s", target.ID, owner, repo))
+		return ctx.Replyf("admin.unregistered", owner, repo, target.ID)
+
+	case "check":
+		cfg, err := ctx.DB.GetGuildConfig(ctx.GuildID)
+		if err != nil {
+			return ctx.Errorf("config.load_error", err)
+		}
+		auditAdminAction(ctx, "check", "")
+		go runGuildCheck(ctx.DB, ctx.Session, cfg, time.Now(), false)
+		return ctx.Replyf("admin.check_started")
+
+	case "channel":
+		channelID := ctx.ChannelOption("channel").ID
+		if err := ctx.DB.SetGuildReportChannel(ctx.GuildID, channelID); err != nil {
+			return ctx.Errorf("config.save_error", err)
+		}
+		auditAdminAction(ctx, "channel", channelID)
+		return ctx.Replyf("config.channel_set", channelID)
+
+	case "role":
+		roleID := ""
+		if role := ctx.RoleOption("role"); role != nil {
+			roleID = role.ID
+		}
+		if err := ctx.DB.SetGuildAdminRole(ctx.GuildID, roleID); err != nil {
+			return ctx.Errorf("config.save_error", err)
+		}
+		auditAdminAction(ctx, "role", roleID)
+		if roleID == "" {
+			return ctx.Replyf("admin.role_cleared")
+		}
+		return ctx.Replyf("admin.role_set", roleID)
+
+	case "health":
+		dbStatus := "✅"
+		if err := ctx.DB.Ping(); err != nil {
+			dbStatus = "❌ " + err.Error()
+		}
+
+		users, repos, registrations, err := ctx.DB.GetGuildStats(ctx.GuildID)
+		if err != nil {
+			log.Printf("Error getting stats for guild %s: %v", ctx.GuildID, err)
+		}
+		cfg, err := ctx.DB.GetGuildConfig(ctx.GuildID)
+		if err != nil {
+			log.Printf("Error getting config for guild %s: %v", ctx.GuildID, err)
+		}
+		lastCheck := cfg.LastCheckDate
+		if lastCheck == "" {
+			lastCheck = "-"
+		}
+
+		auditAdminAction(ctx, "health", "")
+		return ctx.Replyf("admin.health",
+			time.Since(startedAt).Round(time.Second),
+			ctx.Session.HeartbeatLatency().Round(time.Millisecond),
+			dbStatus,
+			users, repos, registrations,
+			lastCheck,
+		)
+
+	case "backup":
+		backup, err := runBackup(ctx.DB)
+		if err != nil {
+			return ctx.Errorf("admin.backup_error", err)
+		}
+		auditAdminAction(ctx, "backup", backup.Name)
+		return ctx.Replyf("admin.backup_done", backup.Name, float64(backup.Size)/(1<<20))
+
+	case "audit":
+		entries, err := ctx.DB.GetAdminAuditLog(ctx.GuildID, 15)
+		if err != nil {
+			return ctx.Errorf("admin.audit_error", err)
+		}
+		if len(entries) == 0 {
+			return ctx.Replyf("admin.audit_empty")
+		}
+
+		var sb strings.Builder
+		for _, e := range entries {
+			sb.WriteString(fmt.Sprintf("<t:%d:f> <@%s> %s %s\n", e.CreatedAt.Unix(), e.UserID, e.Action, e.Details))
+		}
+		return ctx.Reply(sb.String())
+	}
+	return nil
+}
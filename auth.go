@@ -1,16 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
-	"database/sql"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
 )
 
 func verifySignature(secret string, payload []byte, signature string) bool {
@@ -29,10 +36,38 @@ func generateStateToken() string {
 	return hex.EncodeToString(bytes)
 }
 
-func exchangeCodeForToken(code string) (string, error) {
-	reqBody := strings.NewReader(fmt.Sprintf("client_id=%s&client_secret=%s&code=%s", GithubClientID, GithubSecret, code))
+// generateCodeVerifier returns a PKCE code verifier (RFC 7636), 43 characters
+// of base64url.
+func generateCodeVerifier() string {
+	bytes := make([]byte, 32)
+
+	if _, err := rand.Read(bytes); err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// codeChallenge derives the S256 PKCE challenge sent with the authorize URL.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// oauthRedirectURL must match the callback URL configured on the OAuth app.
+func oauthRedirectURL() string {
+	return fmt.Sprintf("%s/github/callback", BaseURL)
+}
 
-	req, err := http.NewRequest("POST", "https://github.com/login/oauth/access_token", reqBody)
+func exchangeCodeForToken(code, codeVerifier string) (string, error) {
+	form := url.Values{
+		"client_id":     {GithubClientID},
+		"client_secret": {GithubSecret},
+		"code":          {code},
+		"redirect_uri":  {oauthRedirectURL()},
+		"code_verifier": {codeVerifier},
+	}
+
+	req, err := http.NewRequest("POST", "https://github.com/login/oauth/access_token", strings.NewReader(form.Encode()))
 	if err != nil {
 		return "", err
 	}
@@ -69,7 +104,7 @@ func exchangeCodeForToken(code string) (string, error) {
 	return result.AccessToken, nil
 }
 
-func createWebhook(db *sql.DB, accessToken, owner, repo, webhookURL string) error {
+func createWebhook(db Store, accessToken, owner, repo, webhookURL string) error {
 	log.Printf("Creating webhook with secret: %s", WebhookSecret)
 	payload := map[string]any{
 		"name":   "web",
@@ -122,7 +157,245 @@ func createWebhook(db *sql.DB, accessToken, owner, repo, webhookURL string) erro
 	}
 	json.NewDecoder(resp.Body).Decode(&result)
 
-	return storeWebhookID(db, owner, repo, result.ID, WebhookSecret)
+	return db.StoreWebhookID(owner, repo, result.ID, WebhookSecret)
+}
+
+var errRepoNotFound = errors.New("repository not found")
+
+// webhookScope is the OAuth scope needed to create and delete repo webhooks.
+const webhookScope = "admin:repo_hook"
+
+// errTokenRejected means GitHub no longer accepts a user's token, usually
+// because the user revoked the OAuth app.
+var errTokenRejected = errors.New("GitHub token was rejected")
+
+// tokenRejected reports whether a response refused the token itself. Only a
+// 401 does: a 403 also comes from rate limits, SSO enforcement and
+// organization access policies, none of which mean the token is gone.
+func tokenRejected(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusUnauthorized
+}
+
+// getGitHubUser returns the token owner's login and granted scopes, taken
+// from the X-OAuth-Scopes header GitHub returns on every call.
+func getGitHubUser(accessToken string) (string, []string, error) {
+	req, err := http.NewRequest("GET", "https://api.github.com/user", nil)
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			log.Printf("Error closing response body: %v", err)
+		}
+	}()
+
+	if tokenRejected(resp) {
+		return "", nil, errTokenRejected
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("failed to get user, status: %d", resp.StatusCode)
+	}
+
+	var user struct {
+		Login string `json:"login"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return "", nil, err
+	}
+
+	var scopes []string
+	for _, scope := range strings.Split(resp.Header.Get("X-OAuth-Scopes"), ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return user.Login, scopes, nil
+}
+
+// tokenHasScope reports whether the token is still valid and was granted
+// scope.
+func tokenHasScope(accessToken, scope string) (bool, error) {
+	_, scopes, err := getGitHubUser(accessToken)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(scopes, scope), nil
+}
+
+// getGitHubRepo looks up a repo and returns its canonical "owner/name". The
+// token, when set, lets private repos the user can see resolve as well.
+func getGitHubRepo(accessToken, owner, repo string) (string, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://api.github.com/repos/%s/%s", owner, repo), nil)
+	if err != nil {
+		return "", err
+	}
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			log.Printf("Error closing response body: %v", err)
+		}
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", errRepoNotFound
+	case http.StatusUnauthorized:
+		if accessToken != "" {
+			return getGitHubRepo("", owner, repo)
+		}
+		return "", fmt.Errorf("failed to look up repository, status: %d", resp.StatusCode)
+	default:
+		return "", fmt.Errorf("failed to look up repository, status: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		FullName string `json:"full_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.FullName, nil
+}
+
+// oauthAuthorizeURL builds the GitHub authorize link for a pending state.
+func oauthAuthorizeURL(state, codeVerifier string) string {
+	return "https://github.com/login/oauth/authorize?" + url.Values{
+		"client_id":             {GithubClientID},
+		"redirect_uri":          {oauthRedirectURL()},
+		"scope":                 {webhookScope},
+		"state":                 {state},
+		"code_challenge":        {codeChallenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+}
+
+// backfillGithubLogins looks up the login of users who authorized before it
+// was recorded, since commits are attributed to members by login.
+func backfillGithubLogins(db Store, dg *discordgo.Session) {
+	users, err := db.GetTokensWithoutGithubLogin()
+	if err != nil {
+		log.Printf("Error getting users without GitHub login: %v", err)
+		return
+	}
+
+	for _, u := range users {
+		login, _, err := getGitHubUser(u.Token)
+		if errors.Is(err, errTokenRejected) {
+			handleRevokedToken(db, dg, u.UserID)
+			continue
+		}
+		if err != nil {
+			log.Printf("Error getting GitHub user for user %s: %v", u.UserID, err)
+			continue
+		}
+		if err := db.StoreGithubToken(u.UserID, u.Token, login); err != nil {
+			log.Printf("Error storing GitHub login for user %s: %v", u.UserID, err)
+		}
+	}
+}
+
+// handleRevokedToken forgets a token GitHub no longer accepts and sends the
+// user a link to authorize again. The DM goes out only once per revocation.
+func handleRevokedToken(db Store, dg *discordgo.Session, userID string) {
+	revoked, err := db.MarkGithubTokenRevoked(userID)
+	if err != nil {
+		log.Printf("Error marking GitHub token revoked for user %s: %v", userID, err)
+		return
+	}
+	if !revoked {
+		return
+	}
+	log.Printf("GitHub token for user %s was revoked", userID)
+
+	if GithubClientID == "" {
+		return
+	}
+
+	locale := defaultLocale
+	if guildIDs, err := db.GetUserGuildIDs(userID); err == nil && len(guildIDs) > 0 {
+		locale = guildLocale(db, dg, guildIDs[0])
+	}
+
+	state := generateStateToken()
+	codeVerifier := generateCodeVerifier()
+	if state == "" || codeVerifier == "" {
+		return
+	}
+	err = db.CreatePendingAuth(state, PendingAuth{
+		DiscordUserID: userID,
+		Locale:        locale,
+		CodeVerifier:  codeVerifier,
+		ExpiresAt:     time.Now().Add(reauthLinkTTL),
+	})
+	if err != nil {
+		log.Printf("Error creating re-authorization state for user %s: %v", userID, err)
+		return
+	}
+
+	channel, err := dg.UserChannelCreate(userID)
+	if err != nil {
+		log.Printf("Error opening DM with user %s: %v", userID, err)
+		return
+	}
+	sendMessage(dg, channel.ID, tr(locale, "token.revoked", oauthAuthorizeURL(state, codeVerifier)))
+}
+
+// listUserRepos returns the full names of repos the token's user can
+// access, most recently pushed first. Only the first page is fetched, which
+// is plenty for suggestions.
+func listUserRepos(accessToken string) ([]string, error) {
+	req, err := http.NewRequest("GET", "https://api.github.com/user/repos?sort=pushed&per_page=100", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			log.Printf("Error closing response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list repos, status: %d", resp.StatusCode)
+	}
+
+	var repos []struct {
+		FullName string `json:"full_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&repos); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(repos))
+	for _, r := range repos {
+		names = append(names, r.FullName)
+	}
+	return names, nil
 }
 
 func deleteGitHubWebhook(accessToken, owner, repo string, webhookID int64) error {
@@ -142,8 +415,152 @@ func deleteGitHubWebhook(accessToken, owner, repo string, webhookID int64) error
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusNoContent {
+	if tokenRejected(resp) {
+		return errTokenRejected
+	}
+	// A 404 means the hook is already gone, which is what we wanted.
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
 		return fmt.Errorf("failed to delete webhook, status: %d", resp.StatusCode)
 	}
 	return nil
 }
+
+// revokeGitHubToken deletes an OAuth token through GitHub's application
+// token API, so it stops working everywhere and not just in our database. A
+// 404 means GitHub no longer knows the token, which is what we wanted.
+func revokeGitHubToken(accessToken string) error {
+	body, err := json.Marshal(map[string]string{"access_token": accessToken})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("DELETE",
+		fmt.Sprintf("https://api.github.com/applications/%s/token", GithubClientID),
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(GithubClientID, GithubSecret)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to revoke token, status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type githubHook struct {
+	ID     int64 `json:"id"`
+	Config struct {
+		URL string `json:"url"`
+	} `json:"config"`
+}
+
+// webhookExists reports whether the hook is still installed on the repo.
+func webhookExists(accessToken, owner, repo string, webhookID int64) (bool, error) {
+	req, err := http.NewRequest("GET",
+		fmt.Sprintf("https://api.github.com/repos/%s/%s/hooks/%d", owner, repo, webhookID),
+		nil,
+	)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("failed to get webhook, status: %d", resp.StatusCode)
+	}
+}
+
+func listWebhooks(accessToken, owner, repo string) ([]githubHook, error) {
+	req, err := http.NewRequest("GET",
+		fmt.Sprintf("https://api.github.com/repos/%s/%s/hooks?per_page=100", owner, repo),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			log.Printf("Error closing response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list webhooks, status: %d", resp.StatusCode)
+	}
+
+	var hooks []githubHook
+	if err := json.NewDecoder(resp.Body).Decode(&hooks); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+// adoptWebhook resets an existing hook's config to ours, since we cannot know
+// the secret it was created with, and records it for the repo.
+func adoptWebhook(db Store, accessToken, owner, repo string, webhookID int64, webhookURL string) error {
+	payload := map[string]any{
+		"active": true,
+		"events": []string{"push"},
+		"config": map[string]any{
+			"url":          webhookURL,
+			"content_type": "json",
+			"secret":       WebhookSecret,
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PATCH",
+		fmt.Sprintf("https://api.github.com/repos/%s/%s/hooks/%d", owner, repo, webhookID),
+		strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to update webhook, status: %d", resp.StatusCode)
+	}
+	return db.StoreWebhookID(owner, repo, webhookID, WebhookSecret)
+}
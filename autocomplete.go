@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// githubReposTTL bounds how often a user's GitHub repos are listed, since
+// Discord sends an autocomplete request on every keystroke.
+const githubReposTTL = 5 * time.Minute
+
+type cachedRepos struct {
+	names     []string
+	fetchedAt time.Time
+}
+
+var (
+	githubReposCache   = make(map[string]cachedRepos)
+	githubReposCacheMu sync.Mutex
+)
+
+// autocompleteGitHubRepos suggests repos the caller can access on GitHub,
+// using the token stored from an earlier registration.
+func autocompleteGitHubRepos(ctx *CommandContext) []*discordgo.ApplicationCommandOptionChoice {
+	name, query := ctx.Focused()
+	if name != "repo" {
+		return nil
+	}
+
+	names, err := githubRepoNames(ctx.DB, ctx.User.ID)
+	if err != nil {
+		log.Printf("Error listing GitHub repos for user %s: %v", ctx.User.ID, err)
+		return nil
+	}
+	return repoChoices(names, query)
+}
+
+// autocompleteRegisteredRepos suggests the caller's repos registered in
+// this guild.
+func autocompleteRegisteredRepos(ctx *CommandContext) []*discordgo.ApplicationCommandOptionChoice {
+	name, query := ctx.Focused()
+	if name != "repo" {
+		return nil
+	}
+	return registeredRepoChoices(ctx.DB, ctx.User.ID, ctx.GuildID, query)
+}
+
+func registeredRepoChoices(db Store, userID, guildID, query string) []*discordgo.ApplicationCommandOptionChoice {
+	repos, err := db.GetReposByUserID(userID, guildID)
+	if err != nil {
+		log.Printf("Error getting repos for user %s: %v", userID, err)
+		return nil
+	}
+
+	names := make([]string, 0, len(repos))
+	for _, r := range repos {
+		names = append(names, r.Owner+"/"+r.Name)
+	}
+	return repoChoices(names, query)
+}
+
+func githubRepoNames(db Store, userID string) ([]string, error) {
+	githubReposCacheMu.Lock()
+	cached, ok := githubReposCache[userID]
+	githubReposCacheMu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < githubReposTTL {
+		return cached.names, nil
+	}
+
+	token, err := db.GetGithubToken(userID)
+	if err == sql.ErrNoRows || (err == nil && token == "") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := listUserRepos(token)
+	if err != nil {
+		return nil, err
+	}
+
+	githubReposCacheMu.Lock()
+	githubReposCache[userID] = cachedRepos{names: names, fetchedAt: time.Now()}
+	githubReposCacheMu.Unlock()
+	return names, nil
+}
+
+// repoChoices filters names by a case-insensitive substring match, dropping
+// duplicates and keeping at most maxChoices.
+func repoChoices(names []string, query string) []*discordgo.ApplicationCommandOptionChoice {
+	query = strings.ToLower(strings.TrimSpace(query))
+	seen := make(map[string]bool)
+
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, name := range names {
+		if seen[name] || !strings.Contains(strings.ToLower(name), query) {
+			continue
+		}
+		seen[name] = true
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: name})
+		if len(choices) == maxChoices {
+			break
+		}
+	}
+	return choices
+}
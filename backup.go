@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backups are named after the UTC time they were taken, like
+// bot-20060102-150405.db.
+const (
+	backupPrefix     = "bot-"
+	backupSuffix     = ".db"
+	backupTimeFormat = "20060102-150405"
+)
+
+var errBackupUnsupported = errors.New("backups are only supported on SQLite, use pg_dump for PostgreSQL")
+
+// backupMu keeps the scheduled and the on-demand backups from running at
+// the same time.
+var backupMu sync.Mutex
+
+type backupFile struct {
+	Name    string
+	Path    string
+	Size    int64
+	TakenAt time.Time
+}
+
+// backupRetention is how many days and ISO weeks keep a backup. The newest
+// backup of each day and of each week is kept, so taking more backups in a
+// day never pushes older days out.
+type backupRetention struct {
+	Daily, Weekly int
+}
+
+func loadBackupRetention() (backupRetention, error) {
+	var keep backupRetention
+	for _, v := range []struct {
+		key      string
+		fallback string
+		dst      *int
+	}{
+		{"BACKUP_KEEP_DAILY", "7", &keep.Daily},
+		{"BACKUP_KEEP_WEEKLY", "4", &keep.Weekly},
+	} {
+		n, err := strconv.Atoi(envOrDefault(v.key, v.fallback))
+		if err != nil || n < 0 {
+			return keep, fmt.Errorf("invalid %s %q", v.key, os.Getenv(v.key))
+		}
+		*v.dst = n
+	}
+	if keep.Daily == 0 {
+		return keep, errors.New("BACKUP_KEEP_DAILY must be at least 1")
+	}
+	return keep, nil
+}
+
+// backupDir is BACKUP_DIR, or a backups directory next to the database.
+func backupDir() string {
+	if BackupDir != "" {
+		return BackupDir
+	}
+	return filepath.Join(filepath.Dir(sqlitePath()), "backups")
+}
+
+// Backup writes a consistent copy of the database to path while the bot
+// keeps running.
+func (s *sqlStore) Backup(path string) error {
+	if s.dialect.name != "sqlite" {
+		return errBackupUnsupported
+	}
+	_, err := s.exec(`VACUUM INTO ?`, path)
+	return err
+}
+
+// scheduleBackups takes a backup whenever the newest one is a day old. It
+// checks hourly rather than sleeping a day so restarts don't skip backups.
+func scheduleBackups(db Store) {
+	for {
+		backups, err := listBackups(backupDir())
+		if err != nil {
+			log.Printf("Error listing backups: %v", err)
+		} else if len(backups) == 0 || time.Since(backups[0].TakenAt) >= 24*time.Hour {
+			if _, err := runBackup(db); err != nil {
+				log.Printf("Error creating backup: %v", err)
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// runBackup takes a backup into the backup directory and rotates old ones.
+// The copy is written under a temporary name first so a backup that fails
+// halfway is never mistaken for a complete one.
+func runBackup(db Store) (backupFile, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	keep, err := loadBackupRetention()
+	if err != nil {
+		return backupFile{}, err
+	}
+
+	dir := backupDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return backupFile{}, fmt.Errorf("failed to create backup dir: %w", err)
+	}
+
+	takenAt := time.Now().UTC()
+	name := backupPrefix + takenAt.Format(backupTimeFormat) + backupSuffix
+	path := filepath.Join(dir, name)
+	tmp := path + ".tmp"
+
+	os.Remove(tmp)
+	if err := db.Backup(tmp); err != nil {
+		os.Remove(tmp)
+		return backupFile{}, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return backupFile{}, fmt.Errorf("failed to save backup: %w", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return backupFile{}, err
+	}
+	log.Printf("Created backup %s", path)
+
+	backups, err := listBackups(dir)
+	if err != nil {
+		log.Printf("Error listing backups: %v", err)
+	}
+	for _, b := range expiredBackups(backups, keep) {
+		if err := os.Remove(b.Path); err != nil {
+			log.Printf("Error removing backup %s: %v", b.Path, err)
+			continue
+		}
+		log.Printf("Removed expired backup %s", b.Path)
+	}
+
+	return backupFile{Name: name, Path: path, Size: info.Size(), TakenAt: takenAt}, nil
+}
+
+// listBackups returns the backups in dir, newest first. Files not named
+// like a backup are left alone.
+func listBackups(dir string) ([]backupFile, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []backupFile
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp, ok := strings.CutPrefix(name, backupPrefix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		stamp, ok = strings.CutSuffix(stamp, backupSuffix)
+		if !ok {
+			continue
+		}
+		takenAt, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, backupFile{Name: name, Path: filepath.Join(dir, name), Size: info.Size(), TakenAt: takenAt})
+	}
+
+	slices.SortFunc(backups, func(a, b backupFile) int {
+		return b.TakenAt.Compare(a.TakenAt)
+	})
+	return backups, nil
+}
+
+// expiredBackups picks the backups retention no longer covers. backups
+// must be sorted newest first.
+func expiredBackups(backups []backupFile, keep backupRetention) []backupFile {
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+
+	var expired []backupFile
+	for _, b := range backups {
+		kept := false
+
+		day := b.TakenAt.Format(time.DateOnly)
+		if !days[day] && len(days) < keep.Daily {
+			days[day] = true
+			kept = true
+		}
+
+		year, w := b.TakenAt.ISOWeek()
+		week := fmt.Sprintf("%d-%02d", year, w)
+		if !weeks[week] && len(weeks) < keep.Weekly {
+			weeks[week] = true
+			kept = true
+		}
+
+		if !kept {
+			expired = append(expired, b)
+		}
+	}
+	return expired
+}
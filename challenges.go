@@ -0,0 +1,232 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func handleChallengeCommand(ctx *CommandContext) error {
+	userID := ctx.User.ID
+	name := strings.TrimSpace(ctx.StringOption("name"))
+
+	if name == "" {
+		return ctx.Errorf("challenge.invalid_name")
+	}
+
+	// Challenge days are the guild's days, the same ones the daily check
+	// evaluates them on.
+	cfg, err := ctx.DB.GetGuildConfig(ctx.GuildID)
+	if err != nil {
+		return ctx.Errorf("challenge.load_error", err)
+	}
+	loc := cfg.Location()
+
+	if ctx.Subcommand == "create" {
+		start, err := time.ParseInLocation(time.DateOnly, ctx.StringOption("start"), loc)
+		if err != nil {
+			return ctx.Errorf("challenge.invalid_start")
+		}
+		end, err := time.ParseInLocation(time.DateOnly, ctx.StringOption("end"), loc)
+		if err != nil {
+			return ctx.Errorf("challenge.invalid_end")
+		}
+		if end.Before(start) {
+			return ctx.Errorf("challenge.end_before_start")
+		}
+
+		challenge := Challenge{
+			GuildID:   ctx.GuildID,
+			ChannelID: ctx.Interaction.ChannelID,
+			CreatorID: userID,
+			Name:      name,
+			MinRepos:  1,
+			Scoring:   "elimination",
+			StartDate: start.Format(time.DateOnly),
+			EndDate:   end.Format(time.DateOnly),
+		}
+		if ctx.Has("scoring") {
+			challenge.Scoring = ctx.StringOption("scoring")
+		}
+		if ctx.Has("min_repos") {
+			challenge.MinRepos = int(ctx.IntOption("min_repos"))
+		}
+
+		if err := ctx.DB.CreateChallenge(challenge); err != nil {
+			return ctx.Errorf("challenge.create_error", err)
+		}
+
+		return ctx.Respond(&discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: renderMessage(ctx.DB, ctx.Session, ctx.GuildID, "challenge_created", MessageData{
+					User:    fmt.Sprintf("<@%s>", userID),
+					Name:    challenge.Name,
+					Count:   challenge.MinRepos,
+					Start:   challenge.StartDate,
+					End:     challenge.EndDate,
+					Scoring: challenge.Scoring,
+				}),
+			},
+		})
+	}
+
+	challenge, err := ctx.DB.GetChallenge(ctx.GuildID, name)
+	if err == sql.ErrNoRows {
+		return ctx.Errorf("challenge.not_found", name)
+	}
+	if err != nil {
+		return ctx.Errorf("challenge.load_error", err)
+	}
+
+	switch ctx.Subcommand {
+	case "join":
+		today := time.Now().In(loc).Format(time.DateOnly)
+		if challenge.Finished || today > challenge.EndDate || (challenge.Scoring == "elimination" && today > challenge.StartDate) {
+			return ctx.Errorf("challenge.closed", challenge.Name)
+		}
+		if err := ctx.DB.JoinChallenge(challenge.ID, userID); err != nil {
+			return ctx.Errorf("challenge.join_error", err)
+		}
+		return ctx.Replyf("challenge.joined", challenge.Name)
+
+	case "leave":
+		left, err := ctx.DB.LeaveChallenge(challenge.ID, userID)
+		if err != nil {
+			return ctx.Errorf("challenge.leave_error", err)
+		}
+		if !left {
+			return ctx.Errorf("challenge.not_participant", challenge.Name)
+		}
+		return ctx.Replyf("challenge.left", challenge.Name)
+
+	case "status":
+		participants, err := ctx.DB.GetChallengeParticipants(challenge.ID)
+		if err != nil {
+			return ctx.Errorf("challenge.participants_error", err)
+		}
+		return ctx.Reply(formatChallengeStandings(ctx.Locale, challenge, participants))
+	}
+	return nil
+}
+
+func formatChallengeStandings(locale discordgo.Locale, challenge Challenge, participants []ChallengeParticipant) string {
+	var sb strings.Builder
+	sb.WriteString(tr(locale, "challenge.standings_header", challenge.Name, challenge.StartDate, challenge.EndDate, challenge.Scoring) + "\n")
+
+	if len(participants) == 0 {
+		sb.WriteString(tr(locale, "challenge.no_participants"))
+		return sb.String()
+	}
+
+	for rank, p := range participants {
+		status := "🔥"
+		if p.Eliminated {
+			status = "💀"
+		}
+		sb.WriteString(tr(locale, "challenge.standing", rank+1, p.UserID, p.Points, status) + "\n")
+	}
+	return sb.String()
+}
+
+// evaluateChallenges records the day for the guild's running challenges and
+// posts the results of those that ended. It reports whether a challenge had
+// to be postponed because not every participant could be checked.
+func evaluateChallenges(db Store, dg *discordgo.Session, guildID string, now time.Time) (postponed bool) {
+	challenges, err := db.GetUnfinishedChallenges(guildID)
+	if err != nil {
+		log.Printf("Error getting challenges: %v", err)
+		return true
+	}
+
+	today := now.Format(time.DateOnly)
+	statusCache := make(map[string]map[string]bool)
+
+	for _, challenge := range challenges {
+		if today < challenge.StartDate || (today <= challenge.EndDate && challenge.LastEval == today) {
+			continue
+		}
+
+		participants, err := db.GetChallengeParticipants(challenge.ID)
+		if err != nil {
+			log.Printf("Error getting participants for challenge %d: %v", challenge.ID, err)
+			postponed = true
+			continue
+		}
+
+		if today <= challenge.EndDate {
+			// Every participant is checked before anything is recorded: if
+			// GitHub fails for one of them the day is left unevaluated and
+			// retried later instead of being settled without them.
+			failed := false
+			for _, p := range participants {
+				if p.Eliminated {
+					continue
+				}
+				if _, ok := statusCache[p.UserID]; ok {
+					continue
+				}
+				status, err := checkDailyCommits(db, dg, p.UserID, guildID)
+				if err != nil {
+					log.Printf("Error checking daily commits for user %s: %v", p.UserID, err)
+					failed = true
+					continue
+				}
+				statusCache[p.UserID] = status
+			}
+			if failed {
+				log.Printf("Postponing evaluation of challenge %d, not every participant could be checked", challenge.ID)
+				postponed = true
+				continue
+			}
+
+			remaining := 0
+			for _, p := range participants {
+				if p.Eliminated {
+					continue
+				}
+
+				reposWithCommits := 0
+				for _, hasCommit := range statusCache[p.UserID] {
+					if hasCommit {
+						reposWithCommits++
+					}
+				}
+
+				passed := reposWithCommits >= challenge.MinRepos
+				if passed || challenge.Scoring != "elimination" {
+					remaining++
+				}
+				if err := db.RecordChallengeDay(challenge.ID, p.UserID, passed, challenge.Scoring == "elimination"); err != nil {
+					log.Printf("Error recording challenge day for user %s: %v", p.UserID, err)
+				}
+			}
+
+			if err := db.MarkChallengeEvaluated(challenge.ID, today); err != nil {
+				log.Printf("Error marking challenge %d evaluated: %v", challenge.ID, err)
+			}
+
+			if today < challenge.EndDate && (remaining > 0 || len(participants) == 0) {
+				continue
+			}
+		}
+
+		participants, err = db.GetChallengeParticipants(challenge.ID)
+		if err != nil {
+			log.Printf("Error getting participants for challenge %d: %v", challenge.ID, err)
+			continue
+		}
+		if err := db.FinishChallenge(challenge.ID); err != nil {
+			log.Printf("Error finishing challenge %d: %v", challenge.ID, err)
+			continue
+		}
+		locale := guildLocale(db, dg, challenge.GuildID)
+		header := renderMessage(db, dg, challenge.GuildID, "challenge_results", MessageData{Name: challenge.Name})
+		sendMessage(dg, challenge.ChannelID, header+"\n"+formatChallengeStandings(locale, challenge, participants))
+	}
+	return postponed
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	migrateUsage = "usage: migrate status | up | down [steps]"
+	restoreUsage = "usage: restore <backup file>"
+)
+
+// runCLI runs a maintenance subcommand instead of the bot. It only needs
+// the database, not the Discord or GitHub configuration.
+func runCLI(args []string) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrateCommand(args[1:])
+	case "restore":
+		return runRestoreCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func runMigrateCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	db, err := openStore()
+	if err != nil {
+		return fmt.Errorf("error opening database: %w", err)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "status":
+		statuses, err := db.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, s := range statuses {
+			state := "pending"
+			switch {
+			case s.Modified:
+				state = "modified"
+			case s.Applied:
+				state = "applied"
+			}
+			fmt.Fprintf(w, "%03d\t%s\t%s\t%s\n", s.Version, s.Name, state, s.AppliedAt)
+		}
+		return w.Flush()
+
+	case "up":
+		return db.Migrate()
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		return db.MigrateDown(steps)
+
+	default:
+		return errors.New(migrateUsage)
+	}
+}
+
+// runRestoreCommand replaces the SQLite database with a backup. The bot must
+// be stopped first, and the restore refuses to run while anything still has
+// the database open. The backup is copied next to the database and checked
+// before anything is replaced, and the current database is kept as
+// <path>.before-restore-<time> rather than deleted.
+func runRestoreCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New(restoreUsage)
+	}
+	if DatabaseURL != "" {
+		return errBackupUnsupported
+	}
+
+	target := sqlitePath()
+	if err := checkDatabaseUnused(target); err != nil {
+		return err
+	}
+
+	staged := target + ".restore"
+	if err := copyFile(args[0], staged); err != nil {
+		return fmt.Errorf("error copying backup: %w", err)
+	}
+	if err := validateBackup(staged); err != nil {
+		removeDatabaseFiles(staged)
+		return fmt.Errorf("backup %s is not usable: %w", args[0], err)
+	}
+
+	if _, err := os.Stat(target); err == nil {
+		saved := target + ".before-restore-" + time.Now().UTC().Format(backupTimeFormat)
+		if _, err := os.Stat(saved); err == nil {
+			removeDatabaseFiles(staged)
+			return fmt.Errorf("%s already exists, try again in a second", saved)
+		}
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			err := os.Rename(target+suffix, saved+suffix)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				removeDatabaseFiles(staged)
+				return fmt.Errorf("error moving current database aside: %w", err)
+			}
+		}
+		fmt.Printf("Moved the current database to %s\n", saved)
+	}
+
+	if err := os.Rename(staged, target); err != nil {
+		return fmt.Errorf("error replacing database: %w", err)
+	}
+	fmt.Printf("Restored %s from %s\n", target, args[0])
+	return nil
+}
+
+// checkDatabaseUnused fails when another process, usually the running bot,
+// has the database at path open. Taking an exclusive lock is only possible
+// when no other connection exists, even an idle one. Closing the probe also
+// folds a -wal left behind by a crash back into the database.
+func checkDatabaseUnused(path string) error {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	// The locking mode has to be set before anything reads the database,
+	// which rules out sqliteDSN and its journal_mode pragma. WAL is stored
+	// in the file and doesn't need setting again.
+	params := url.Values{}
+	params.Add("_pragma", "locking_mode(EXCLUSIVE)")
+	params.Add("_pragma", "busy_timeout("+envOrDefault("SQLITE_BUSY_TIMEOUT_MS", "5000")+")")
+	db, err := newStore(sqliteDialect, path+"?"+params.Encode())
+	if err == nil {
+		defer db.Close()
+		var n int
+		err = db.queryRow(`SELECT COUNT(*) FROM sqlite_master`).Scan(&n)
+	}
+	if err != nil {
+		return fmt.Errorf("database %s is in use, stop the bot before restoring: %w", path, err)
+	}
+	return nil
+}
+
+// validateBackup checks that path is an intact database of this bot whose
+// migrations this build knows. Pending migrations are fine, they are applied
+// when the bot starts.
+func validateBackup(path string) error {
+	db, err := newStore(sqliteDialect, sqliteDSN(path))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	problems, err := db.integrityProblems()
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("database is corrupt: %s", strings.Join(problems, "; "))
+	}
+
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	if len(statuses) == 0 || !statuses[0].Applied {
+		return errors.New("no migrations applied, this is not a bot database")
+	}
+	for _, status := range statuses {
+		if status.Modified {
+			return fmt.Errorf("migration %03d_%s differs from this build", status.Version, status.Name)
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func removeDatabaseFiles(path string) {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		os.Remove(path + suffix)
+	}
+}
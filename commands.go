@@ -2,10 +2,10 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -13,143 +13,804 @@ import (
 
 type PendingAuth struct {
 	DiscordUserID string
+	GuildID       string
 	Owner         string
 	Repo          string
 	ChannelID     string
-	ExpiresAt     time.Time
+	Locale        discordgo.Locale
+	// CodeVerifier is the PKCE secret sent with the token exchange.
+	CodeVerifier string
+	// InteractionToken lets the callback update the /register reply; it
+	// outlives pendingAuthTTL by a few minutes.
+	InteractionToken string
+	ExpiresAt        time.Time
 }
 
-var (
-	pendingAuths   = make(map[string]PendingAuth)
-	pendingAuthsMu sync.Mutex
-)
+// pendingAuthTTL is how long a /register authorization link stays valid.
+const pendingAuthTTL = 10 * time.Minute
+
+// reauthLinkTTL is how long the link sent after a token was revoked stays
+// valid. It is not tied to an interaction, so it can outlive pendingAuthTTL.
+const reauthLinkTTL = 24 * time.Hour
+
+var commandHandlers = map[string]Command{
+	"register":    {Handler: handleRegisterCommand, GuildOnly: true, Defer: true, Autocomplete: autocompleteGitHubRepos},
+	"unregister":  {Handler: handleUnregisterCommand, GuildOnly: true, Autocomplete: autocompleteRegisteredRepos},
+	"buddy":       {Handler: handleBuddyCommand, GuildOnly: true},
+	"challenge":   {Handler: handleChallengeCommand, GuildOnly: true},
+	"streakrole":  {Handler: handleStreakRoleCommand, GuildOnly: true},
+	"badges":      {Handler: handleBadgesCommand},
+	"template":    {Handler: handleTemplateCommand, GuildOnly: true},
+	"language":    {Handler: handleLanguageCommand, GuildOnly: true},
+	"config":      {Handler: handleConfigCommand, GuildOnly: true},
+	"leaderboard": {Handler: handleLeaderboardCommand, GuildOnly: true},
+	"admin":       {Handler: handleAdminCommand, GuildOnly: true, Autocomplete: autocompleteAdminRepos},
+	"check":       {Handler: handleCheckCommand, Defer: true},
+	"unlink":      {Handler: handleUnlinkCommand},
+	"export":      {Handler: handleExportCommand},
+}
+
+var guildOnlyContexts = []discordgo.InteractionContextType{discordgo.InteractionContextGuild}
+
+func registerCommands(dg *discordgo.Session, db Store) {
+	for _, cmd := range commands {
+		if commandHandlers[cmd.Name].GuildOnly {
+			cmd.Contexts = &guildOnlyContexts
+		}
+	}
 
-func registerCommands(dg *discordgo.Session, db *sql.DB) {
 	dg.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		log.Printf("Received interaction at: %v", time.Now())
-		if i.Type != discordgo.InteractionApplicationCommand {
-			return
+
+		switch i.Type {
+		case discordgo.InteractionApplicationCommand:
+			dispatchCommand(s, db, i)
+		case discordgo.InteractionApplicationCommandAutocomplete:
+			dispatchAutocomplete(s, db, i)
+		case discordgo.InteractionMessageComponent:
+			dispatchComponent(s, db, i)
 		}
+	})
+}
 
-		switch i.ApplicationCommandData().Name {
-		case "register":
-			repoInput := i.ApplicationCommandData().Options[0].StringValue()
-			userID := i.Member.User.ID
-			parts := strings.Split(repoInput, "/")
-			if len(parts) != 2 {
-				s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-					Type: discordgo.InteractionResponseChannelMessageWithSource,
-					Data: &discordgo.InteractionResponseData{
-						Content: "Invalid format, please use owner/repo",
-						Flags:   discordgo.MessageFlagsEphemeral,
-					},
-				})
-				return
-			}
+// handleRegisterCommand validates the repo against GitHub before sending
+// the user through OAuth. Repos that already have a webhook are attached
+// right away, since nobody needs to authorize a second hook, and a stored
+// token that is still valid is reused instead of asking again.
+func handleRegisterCommand(ctx *CommandContext) error {
+	owner, repo, ok := parseRepoInput(ctx.StringOption("repo"))
+	if !ok {
+		return ctx.Errorf("repo.invalid_format")
+	}
+	if githubAppEnabled() {
+		return registerWithGitHubApp(ctx, owner, repo)
+	}
+
+	token, err := ctx.DB.GetGithubToken(ctx.User.ID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error getting GitHub token for user %s: %v", ctx.User.ID, err)
+	}
+	fullName, err := getGitHubRepo(token, owner, repo)
+	if errors.Is(err, errRepoNotFound) {
+		return ctx.Errorf("register.not_found", owner+"/"+repo)
+	}
+	if err != nil {
+		return ctx.Errorf("register.lookup_error", err)
+	}
+	owner, repo, _ = strings.Cut(fullName, "/")
 
-			owner, repo := parts[0], parts[1]
-			stateToken := generateStateToken()
+	registered, err := ctx.DB.IsRepoRegistered(ctx.User.ID, owner, repo, ctx.GuildID)
+	if err != nil {
+		return err
+	}
+	if registered {
+		return ctx.Errorf("register.already_registered", fullName)
+	}
 
-			pendingAuthsMu.Lock()
-			pendingAuths[stateToken] = PendingAuth{
-				DiscordUserID: userID,
-				Owner:         owner,
-				Repo:          repo,
-				ChannelID:     i.ChannelID,
-				ExpiresAt:     time.Now().Add(10 * time.Minute),
+	webhookID, err := ctx.DB.GetRepoWebhookID(owner, repo)
+	if err != nil {
+		return err
+	}
+	if webhookID != 0 && !storedWebhookExists(ctx.DB, token, owner, repo, webhookID) {
+		// Go through the normal path, which recreates the hook.
+		webhookID = 0
+	}
+	if webhookID != 0 {
+		if err := ctx.DB.RegisterRepo(ctx.User.ID, owner, repo, ctx.GuildID, ctx.Interaction.ChannelID); err != nil {
+			return ctx.Errorf("register.error", err)
+		}
+		return ctx.Replyf("register.already_tracked", fullName)
+	}
+
+	if token != "" {
+		ok, err := tokenHasScope(token, webhookScope)
+		if errors.Is(err, errTokenRejected) {
+			if _, err := ctx.DB.MarkGithubTokenRevoked(ctx.User.ID); err != nil {
+				log.Printf("Error marking GitHub token revoked for user %s: %v", ctx.User.ID, err)
 			}
-			pendingAuthsMu.Unlock()
-
-			authURL := fmt.Sprintf(
-				"https://github.com/login/oauth/authorize?client_id=%s&scope=admin:repo_hook&state=%s",
-				GithubClientID, stateToken,
-			)
-
-			err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Content: fmt.Sprintf("Click here to authorize GitHub access: %s\n*(Link expires in 10 minutes)*", authURL),
-					Flags:   discordgo.MessageFlagsEphemeral,
-				},
-			})
-			if err != nil {
-				log.Printf("Error responding to interaction: %v", err)
+		} else if err != nil {
+			log.Printf("Error checking GitHub token for user %s: %v", ctx.User.ID, err)
+		}
+		if ok {
+			if err := completeRegistration(ctx.DB, ctx.Session, token, ctx.User.ID, owner, repo, ctx.GuildID, ctx.Interaction.ChannelID); err != nil {
+				return ctx.Errorf("register.error", err)
 			}
+			return ctx.Replyf("register.linked", fullName)
+		}
+	}
 
-		case "unregister":
-			repoInput := i.ApplicationCommandData().Options[0].StringValue()
-			userID := i.Member.User.ID
-			parts := strings.Split(repoInput, "/")
-			if len(parts) != 2 {
-				s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-					Type: discordgo.InteractionResponseChannelMessageWithSource,
-					Data: &discordgo.InteractionResponseData{
-						Content: "Invalid format, please use owner/repo",
-						Flags:   discordgo.MessageFlagsEphemeral,
-					},
-				})
-				return
-			}
+	stateToken := generateStateToken()
+	codeVerifier := generateCodeVerifier()
+	if stateToken == "" || codeVerifier == "" {
+		return fmt.Errorf("error generating OAuth state")
+	}
 
-			owner, repo := parts[0], parts[1]
+	err = ctx.DB.CreatePendingAuth(stateToken, PendingAuth{
+		DiscordUserID:    ctx.User.ID,
+		GuildID:          ctx.GuildID,
+		Owner:            owner,
+		Repo:             repo,
+		ChannelID:        ctx.Interaction.ChannelID,
+		Locale:           ctx.Locale,
+		CodeVerifier:     codeVerifier,
+		InteractionToken: ctx.Interaction.Token,
+		ExpiresAt:        time.Now().Add(pendingAuthTTL),
+	})
+	if err != nil {
+		return err
+	}
 
-			webHookID, shouldDelete, err := unregisterRepo(db, userID, owner, repo)
-			if err != nil {
-				s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-					Type: discordgo.InteractionResponseChannelMessageWithSource,
-					Data: &discordgo.InteractionResponseData{
-						Content: fmt.Sprintf("Error unregistering repository: %v", err),
-						Flags:   discordgo.MessageFlagsEphemeral,
-					},
-				})
-				return
-			}
+	return ctx.Replyf("register.authorize", oauthAuthorizeURL(stateToken, codeVerifier))
+}
+
+// completeRegistration registers the repo, makes sure it has our webhook
+// (reusing one that already exists) and announces it in the channel.
+func completeRegistration(db Store, dg *discordgo.Session, token, userID, owner, repo, guildID, channelID string) error {
+	if err := db.RegisterRepo(userID, owner, repo, guildID, channelID); err != nil {
+		return fmt.Errorf("error registering repo: %w", err)
+	}
+
+	if err := ensureWebhook(db, token, owner, repo); err != nil {
+		if _, _, err := db.UnregisterRepo(userID, owner, repo, guildID); err != nil {
+			log.Printf("Error rolling back registration of %s/%s: %v", owner, repo, err)
+		}
+		return fmt.Errorf("error setting up GitHub webhook: %w", err)
+	}
+
+	announceRegistration(db, dg, userID, owner, repo, guildID, channelID)
+	return nil
+}
+
+func announceRegistration(db Store, dg *discordgo.Session, userID, owner, repo, guildID, channelID string) {
+	log.Printf("Registered repo %s/%s for user %s in channel %s", owner, repo, userID, channelID)
+	sendMessage(dg, channelID, renderMessage(db, dg, guildID, "repo_registered", MessageData{
+		User:  fmt.Sprintf("<@%s>", userID),
+		Owner: owner,
+		Repo:  repo,
+	}))
+}
+
+func handleUnregisterCommand(ctx *CommandContext) error {
+	owner, repo, ok := parseRepoInput(ctx.StringOption("repo"))
+	if !ok {
+		return ctx.Errorf("repo.invalid_format")
+	}
+
+	if err := removeRegistration(ctx.DB, ctx.Session, ctx.User.ID, owner, repo, ctx.GuildID); err != nil {
+		return ctx.Errorf("unregister.error", err)
+	}
+	return ctx.Replyf("unregister.success", owner, repo)
+}
+
+// handleCheckCommand runs the daily check for the caller right away. In DMs
+// it covers the caller's repos across all guilds. GitHub calls can take
+// longer than Discord's 3 second window, so the command is deferred.
+func handleCheckCommand(ctx *CommandContext) error {
+	report, err := buildDailyReport(ctx.DB, ctx.Session, ctx.GuildID, ctx.User.ID, false)
+	if err != nil {
+		return ctx.Errorf("check.error", err)
+	}
+	return ctx.Reply(report)
+}
+
+// removeRegistration unregisters the repo for the user in the guild and, when
+// nobody else tracks it any more, deletes its GitHub webhook with the user's
+// token. Failed deletes are left for reconcileWebhooks to retry.
+func removeRegistration(db Store, dg *discordgo.Session, userID, owner, repo, guildID string) error {
+	webHookID, shouldDelete, err := db.UnregisterRepo(userID, owner, repo, guildID)
+	if err != nil {
+		return err
+	}
 
-			if shouldDelete {
-				token, err := getGithubToken(db, userID)
-				if err != nil {
-					log.Printf("Error getting GitHub token for user %s: %v", userID, err)
-				} else {
-					if err := deleteGitHubWebhook(token, owner, repo, webHookID); err != nil {
-						log.Printf("Error deleting GitHub webhook for %s/%s: %v", owner, repo, err)
-					}
-				}
+	if shouldDelete && webHookID != 0 {
+		token, err := db.GetGithubToken(userID)
+		if err == nil {
+			err = deleteGitHubWebhook(token, owner, repo, webHookID)
+		}
+		if errors.Is(err, errTokenRejected) {
+			handleRevokedToken(db, dg, userID)
+		}
+		if err != nil {
+			log.Printf("Error deleting GitHub webhook for %s/%s, will retry: %v", owner, repo, err)
+			if err := db.AddOrphanedWebhook(owner, repo, webHookID, userID); err != nil {
+				log.Printf("Error recording orphaned webhook for %s/%s: %v", owner, repo, err)
 			}
+		}
+	}
+	return nil
+}
+
+func handleBuddyCommand(ctx *CommandContext) error {
+	userID := ctx.User.ID
 
-			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Content: fmt.Sprintf("Successfully unregistered repository %s/%s", owner, repo),
-					Flags:   discordgo.MessageFlagsEphemeral,
+	switch ctx.Subcommand {
+	case "add":
+		partner := ctx.UserOption("user")
+		if partner.ID == userID || partner.Bot {
+			return ctx.Errorf("buddy.invalid_partner")
+		}
+
+		if err := ctx.DB.RequestBuddy(userID, partner.ID); err != nil {
+			return ctx.Errorf("buddy.request_error", err)
+		}
+
+		locale := guildLocale(ctx.DB, ctx.Session, ctx.GuildID)
+		return ctx.Respond(&discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: renderMessage(ctx.DB, ctx.Session, ctx.GuildID, "buddy_request", MessageData{
+					User:  fmt.Sprintf("<@%s>", userID),
+					Buddy: fmt.Sprintf("<@%s>", partner.ID),
+				}),
+				Components: []discordgo.MessageComponent{
+					discordgo.ActionsRow{
+						Components: []discordgo.MessageComponent{
+							discordgo.Button{
+								Label:    tr(locale, "buddy.accept"),
+								Style:    discordgo.SuccessButton,
+								CustomID: "buddy_accept:" + userID,
+							},
+							discordgo.Button{
+								Label:    tr(locale, "buddy.decline"),
+								Style:    discordgo.DangerButton,
+								CustomID: "buddy_decline:" + userID,
+							},
+						},
+					},
 				},
-			})
+			},
+		})
 
+	case "remove":
+		buddyID, err := ctx.DB.RemoveBuddy(userID)
+		if err != nil {
+			return ctx.Errorf("buddy.remove_error", err)
 		}
-	})
+		if buddyID == "" {
+			return ctx.Replyf("buddy.none")
+		}
+		return ctx.Replyf("buddy.removed", buddyID)
+	}
+	return nil
+}
+
+func handleComponent(ctx *CommandContext, customID string) error {
+	action, arg, _ := strings.Cut(customID, ":")
+	userID := ctx.User.ID
+
+	switch action {
+	case "buddy_accept", "buddy_decline":
+		accept := action == "buddy_accept"
+		if err := ctx.DB.RespondToBuddyRequest(arg, userID, accept); err != nil {
+			return ctx.Errorf("buddy.answer_error", err)
+		}
+
+		key := "buddy_declined"
+		if accept {
+			key = "buddy_accepted"
+		}
+		content := renderMessage(ctx.DB, ctx.Session, ctx.GuildID, key, MessageData{
+			User:  fmt.Sprintf("<@%s>", userID),
+			Buddy: fmt.Sprintf("<@%s>", arg),
+		})
+
+		return ctx.Respond(&discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseUpdateMessage,
+			Data: &discordgo.InteractionResponseData{
+				Content:    content,
+				Components: []discordgo.MessageComponent{},
+			},
+		})
+
+	case "unlink_confirm", "unlink_cancel":
+		return handleUnlinkButton(ctx, action == "unlink_confirm", arg)
+	}
+	return nil
 }
 
+// syncCommands replaces the registered command set with commands in a single
+// bulk overwrite, so commands removed from the code also disappear from
+// Discord. With devGuildID set, commands are registered to that guild only,
+// where updates apply instantly instead of after global propagation. Commands
+// registered in the other scope are removed so none show up twice.
+func syncCommands(dg *discordgo.Session, appID, devGuildID string) {
+	scope := "globally"
+	if devGuildID != "" {
+		scope = "in dev guild " + devGuildID
+	}
+
+	created, err := dg.ApplicationCommandBulkOverwrite(appID, devGuildID, commands)
+	if err != nil {
+		log.Printf("Error registering commands %s: %v", scope, err)
+		return
+	}
+	log.Printf("Registered %d commands %s", len(created), scope)
+
+	if devGuildID != "" {
+		existing, err := dg.ApplicationCommands(appID, "")
+		if err != nil || len(existing) == 0 {
+			return
+		}
+		if _, err := dg.ApplicationCommandBulkOverwrite(appID, "", nil); err != nil {
+			log.Printf("Error removing stale global commands: %v", err)
+			return
+		}
+		log.Printf("Removed %d stale global commands", len(existing))
+		return
+	}
+
+	for _, guild := range dg.State.Guilds {
+		existing, err := dg.ApplicationCommands(appID, guild.ID)
+		if err != nil || len(existing) == 0 {
+			continue
+		}
+		if _, err := dg.ApplicationCommandBulkOverwrite(appID, guild.ID, nil); err != nil {
+			log.Printf("Error removing stale commands from guild %s: %v", guild.ID, err)
+			continue
+		}
+		log.Printf("Removed %d stale guild commands from guild %s", len(existing), guild.ID)
+	}
+}
+
+var (
+	minValueOne           = 1.0
+	manageRolesPermission = int64(discordgo.PermissionManageRoles)
+	manageGuildPermission = int64(discordgo.PermissionManageGuild)
+)
+
 var commands = []*discordgo.ApplicationCommand{
 	{
 		Name:        "register",
 		Description: "Register a GitHub repository to watch",
 		Options: []*discordgo.ApplicationCommandOption{
 			{
-				Type:        discordgo.ApplicationCommandOptionString,
-				Name:        "repo",
-				Description: "Repository in format owner/repo",
-				Required:    true,
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "repo",
+				Description:  "Repository as owner/repo or a GitHub URL",
+				Required:     true,
+				Autocomplete: true,
 			},
 		},
 	},
 	{
 		Name:        "unregister",
 		Description: "Unregister a GitHub repository",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "repo",
+				Description:  "Repository in format owner/repo",
+				Required:     true,
+				Autocomplete: true,
+			},
+		},
+	},
+	{
+		Name:        "buddy",
+		Description: "Manage your accountability buddy",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "add",
+				Description: "Ask another member to be your accountability buddy",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionUser,
+						Name:        "user",
+						Description: "Member to pair with",
+						Required:    true,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "remove",
+				Description: "Stop being accountability buddies",
+			},
+		},
+	},
+	{
+		Name:        "challenge",
+		Description: "Run team commit challenges",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "create",
+				Description: "Create a new challenge",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "name",
+						Description: "Challenge name",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "start",
+						Description: "Start date (YYYY-MM-DD)",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "end",
+						Description: "End date (YYYY-MM-DD)",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "scoring",
+						Description: "How participants are scored",
+						Choices: []*discordgo.ApplicationCommandOptionChoice{
+							{Name: "Elimination", Value: "elimination"},
+							{Name: "Points", Value: "points"},
+						},
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "min_repos",
+						Description: "Repos that need a commit each day (default 1)",
+						MinValue:    &minValueOne,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "join",
+				Description: "Join a challenge",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "name",
+						Description: "Challenge name",
+						Required:    true,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "leave",
+				Description: "Leave a challenge",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "name",
+						Description: "Challenge name",
+						Required:    true,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "status",
+				Description: "Show challenge standings",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "name",
+						Description: "Challenge name",
+						Required:    true,
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:                     "streakrole",
+		Description:              "Reward streak milestones with roles",
+		DefaultMemberPermissions: &manageRolesPermission,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "set",
+				Description: "Assign a role when members reach a streak",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "threshold",
+						Description: "Streak length in days",
+						Required:    true,
+						MinValue:    &minValueOne,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionRole,
+						Name:        "role",
+						Description: "Role to assign",
+						Required:    true,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "remove",
+				Description: "Remove a streak role reward",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "threshold",
+						Description: "Streak length in days",
+						Required:    true,
+						MinValue:    &minValueOne,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "List streak role rewards",
+			},
+		},
+	},
+	{
+		Name:        "badges",
+		Description: "Show unlocked achievement badges",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionUser,
+				Name:        "user",
+				Description: "Member to show badges for (defaults to you)",
+			},
+		},
+	},
+	{
+		Name:                     "template",
+		Description:              "Customize the bot's messages for this server",
+		DefaultMemberPermissions: &manageGuildPermission,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "set",
+				Description: "Override a message with a Go text/template",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "key",
+						Description: "Message to customize",
+						Required:    true,
+						Choices:     messageKeyChoices(),
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "text",
+						Description: "Template text, e.g. {{.User}} made {{.Count}} commits",
+						Required:    true,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "reset",
+				Description: "Restore a message to the tone preset",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "key",
+						Description: "Message to customize",
+						Required:    true,
+						Choices:     messageKeyChoices(),
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "tone",
+				Description: "Pick a tone preset for all messages",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "preset",
+						Description: "Tone preset",
+						Required:    true,
+						Choices:     toneChoices(),
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "show",
+				Description: "Preview the current messages",
+			},
+		},
+	},
+	{
+		Name:                     "language",
+		Description:              "Set the language used for this server's channel posts",
+		DefaultMemberPermissions: &manageGuildPermission,
 		Options: []*discordgo.ApplicationCommandOption{
 			{
 				Type:        discordgo.ApplicationCommandOptionString,
-				Name:        "repo",
-				Description: "Repository in format owner/repo",
+				Name:        "locale",
+				Description: "Language",
 				Required:    true,
+				Choices:     languageChoices(),
+			},
+		},
+	},
+	{
+		Name:                     "config",
+		Description:              "Configure the bot for this server",
+		DefaultMemberPermissions: &manageGuildPermission,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "channel",
+				Description: "Post daily reports to one channel (leave empty to use each registration's channel)",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:         discordgo.ApplicationCommandOptionChannel,
+						Name:         "channel",
+						Description:  "Report channel",
+						ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "schedule",
+				Description: "Set when the daily check runs",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "timezone",
+						Description: "IANA timezone, e.g. Europe/Berlin",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "time",
+						Description: "Time of day in 24h format, e.g. 20:00",
+						Required:    true,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "feature",
+				Description: "Enable or disable a feature",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "name",
+						Description: "Feature",
+						Required:    true,
+						Choices:     featureChoices(),
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+						Name:        "enabled",
+						Description: "Whether the feature is enabled",
+						Required:    true,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "show",
+				Description: "Show the current configuration",
+			},
+		},
+	},
+	{
+		Name:        "leaderboard",
+		Description: "Show the longest commit streaks in this server",
+	},
+	{
+		Name:                     "admin",
+		Description:              "Moderator tools",
+		DefaultMemberPermissions: &manageGuildPermission,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "unregister",
+				Description: "Force-unregister a member's repository",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionUser,
+						Name:        "user",
+						Description: "Member who registered the repository",
+						Required:    true,
+					},
+					{
+						Type:         discordgo.ApplicationCommandOptionString,
+						Name:         "repo",
+						Description:  "Repository in format owner/repo",
+						Required:     true,
+						Autocomplete: true,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "check",
+				Description: "Post a preview of today's reports for this server",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "channel",
+				Description: "Change the daily report channel",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:         discordgo.ApplicationCommandOptionChannel,
+						Name:         "channel",
+						Description:  "Report channel",
+						Required:     true,
+						ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "role",
+				Description: "Set the role allowed to use admin commands",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionRole,
+						Name:        "role",
+						Description: "Admin role (leave empty to require Manage Server)",
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "health",
+				Description: "Show bot health",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "audit",
+				Description: "Show recent admin actions",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "backup",
+				Description: "Back up the bot's database now",
+			},
+		},
+	},
+	{
+		Name:        "check",
+		Description: "Check today's commits right now",
+	},
+	{
+		Name:        "unlink",
+		Description: "Revoke GitHub access and delete all your data",
+	},
+	{
+		Name:        "export",
+		Description: "Get a copy of everything the bot stores about you by DM",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "format",
+				Description: "File format (default JSON)",
+				Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{Name: "JSON", Value: "json"},
+					{Name: "CSV", Value: "csv"},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionUser,
+				Name:        "user",
+				Description: "Admins only: member to export, limited to this server",
 			},
 		},
 	},
@@ -1,69 +1,397 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"crypto/sha256"
+	"database/sql/driver"
+	"embed"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
-	"os"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
-
-	_ "modernc.org/sqlite"
 )
 
-func runMigrations(db *sql.DB) error {
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+// migrationFiles holds the migrations of every dialect, so the binary does
+// not depend on the working directory it is started from.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrationName matches NNN_name.sql and its revert, NNN_name.down.sql.
+var migrationName = regexp.MustCompile(`^(\d{3})_([a-z0-9_]+?)(\.down)?\.sql$`)
+
+type migration struct {
+	Version  int
+	Name     string
+	Up, Down string
+	Checksum string
+}
+
+// MigrationStatus is a migration as known to both the binary and the
+// database.
+type MigrationStatus struct {
+	migration
+	Applied   bool
+	AppliedAt string
+	// Modified means the file changed after it was applied.
+	Modified bool
+}
+
+// loadMigrations reads a dialect's migrations, ordered by version. Versions
+// must start at 1 without gaps or duplicates, and every migration needs a
+// down file so it can be reverted.
+func loadMigrations(dir string) ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations dir: %w", err)
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		m := migrationName.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("invalid migration file name %s, want NNN_name.sql or NNN_name.down.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+
+		content, err := fs.ReadFile(migrationFiles, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("duplicate migration version %03d: %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] != "" {
+			mig.Down = string(content)
+		} else {
+			sum := sha256.Sum256(content)
+			mig.Up = string(content)
+			mig.Checksum = hex.EncodeToString(sum[:])
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for version := 1; version <= len(byVersion); version++ {
+		mig, ok := byVersion[version]
+		if !ok {
+			return nil, fmt.Errorf("migration %03d is missing", version)
+		}
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %03d_%s has no up file", version, mig.Name)
+		}
+		if mig.Down == "" {
+			return nil, fmt.Errorf("migration %03d_%s has no down file", version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	return migrations, nil
+}
+
+// ensureMigrationsTable creates schema_migrations, adding the name and
+// checksum columns to tables created before they were tracked.
+func (s *sqlStore) ensureMigrationsTable() error {
+	_, err := s.exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
         version INTEGER PRIMARY KEY,
-        applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        name TEXT NOT NULL DEFAULT '',
+        checksum TEXT NOT NULL DEFAULT ''
     )`)
 	if err != nil {
 		return fmt.Errorf("failed to create migrations table: %w", err)
 	}
 
-	entries, err := os.ReadDir("migrations")
+	if _, err := s.exec(`SELECT checksum FROM schema_migrations LIMIT 1`); err == nil {
+		return nil
+	}
+	for _, column := range []string{"name", "checksum"} {
+		_, err := s.exec(`ALTER TABLE schema_migrations ADD COLUMN ` + column + ` TEXT NOT NULL DEFAULT ''`)
+		if err != nil {
+			return fmt.Errorf("failed to add %s to migrations table: %w", column, err)
+		}
+	}
+	return nil
+}
+
+// MigrationStatus compares the embedded migrations with the ones recorded
+// in the database. Migrations applied before checksums were recorded are
+// trusted and get the current checksum.
+func (s *sqlStore) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(s.dialect.migrations)
 	if err != nil {
-		return fmt.Errorf("failed to read migrations dir: %w", err)
+		return nil, err
+	}
+	if err := s.ensureMigrationsTable(); err != nil {
+		return nil, err
 	}
 
-	for _, entry := range entries {
-		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
-			continue
+	rows, err := s.query(`SELECT version, CAST(applied_at AS TEXT), checksum FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
 		}
+	}()
 
+	type applied struct{ appliedAt, checksum string }
+	recorded := make(map[int]applied)
+	for rows.Next() {
 		var version int
-		fmt.Sscanf(entry.Name(), "%d", &version)
-
-		var count int
-		db.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE version = ?", version).Scan(&count)
-		if count > 0 {
-			continue
+		var a applied
+		if err := rows.Scan(&version, &a.appliedAt, &a.checksum); err != nil {
+			return nil, fmt.Errorf("failed to read applied migrations: %w", err)
 		}
+		recorded[version] = a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
 
-		content, err := os.ReadFile("migrations/" + entry.Name())
-		if err != nil {
-			return fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{migration: m}
+		if a, ok := recorded[m.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = a.appliedAt
+			if a.checksum == "" {
+				_, err := s.exec(`UPDATE schema_migrations SET name = ?, checksum = ? WHERE version = ?`, m.Name, m.Checksum, m.Version)
+				if err != nil {
+					return nil, fmt.Errorf("failed to record checksum of migration %03d: %w", m.Version, err)
+				}
+			} else {
+				status.Modified = a.checksum != m.Checksum
+			}
+			delete(recorded, m.Version)
 		}
+		statuses = append(statuses, status)
+	}
 
-		tx, err := db.Begin()
-		if err != nil {
-			return fmt.Errorf("failed to begin transaction: %w", err)
+	for version := range recorded {
+		return nil, fmt.Errorf("database has migration %03d, which this build does not know; is the binary older than the database?", version)
+	}
+	return statuses, nil
+}
+
+// Migrate applies every pending migration, each in its own transaction. It
+// refuses to run when an applied migration was edited since.
+func (s *sqlStore) Migrate() error {
+	statuses, err := s.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		if status.Modified {
+			return fmt.Errorf("migration %03d_%s was edited after it was applied; add a new migration instead", status.Version, status.Name)
 		}
+	}
 
-		if _, err := tx.Exec(string(content)); err != nil {
-			tx.Rollback()
-			return fmt.Errorf("failed to apply migration %s: %w", entry.Name(), err)
+	for _, status := range statuses {
+		if status.Applied {
+			continue
+		}
+		if err := s.applyMigration(status.migration); err != nil {
+			return err
 		}
+		log.Printf("Applied migration: %03d_%s", status.Version, status.Name)
+	}
+	return nil
+}
+
+func (s *sqlStore) applyMigration(m migration) error {
+	tx, done, err := s.beginMigration()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer done()
 
-		if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES (?)", version); err != nil {
-			tx.Rollback()
-			return fmt.Errorf("failed to record migration %s: %w", entry.Name(), err)
+	before, err := tx.foreignKeyViolations()
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to check foreign keys: %w", err)
+	}
+
+	if _, err := tx.Exec(m.Up); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to apply migration %03d_%s: %w", m.Version, m.Name, err)
+	}
+
+	if err := tx.checkForeignKeys(before); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %03d_%s breaks foreign keys: %w", m.Version, m.Name, err)
+	}
+
+	_, err = tx.exec(`INSERT INTO schema_migrations (version, name, checksum) VALUES (?, ?, ?)`, m.Version, m.Name, m.Checksum)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to record migration %03d_%s: %w", m.Version, m.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %03d_%s: %w", m.Version, m.Name, err)
+	}
+	return nil
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first.
+func (s *sqlStore) MigrateDown(steps int) error {
+	statuses, err := s.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	slices.Reverse(statuses)
+	for _, status := range statuses {
+		if steps == 0 {
+			break
+		}
+		if !status.Applied {
+			continue
+		}
+		if err := s.revertMigration(status.migration); err != nil {
+			return err
 		}
+		log.Printf("Reverted migration: %03d_%s", status.Version, status.Name)
+		steps--
+	}
+	return nil
+}
+
+func (s *sqlStore) revertMigration(m migration) error {
+	tx, done, err := s.beginMigration()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer done()
+
+	before, err := tx.foreignKeyViolations()
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to check foreign keys: %w", err)
+	}
+
+	if _, err := tx.Exec(m.Down); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to revert migration %03d_%s: %w", m.Version, m.Name, err)
+	}
 
-		if err := tx.Commit(); err != nil {
-			return fmt.Errorf("failed to commit migration %s: %w", entry.Name(), err)
+	if err := tx.checkForeignKeys(before); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("reverting migration %03d_%s breaks foreign keys: %w", m.Version, m.Name, err)
+	}
+
+	if _, err := tx.exec(`DELETE FROM schema_migrations WHERE version = ?`, m.Version); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to unrecord migration %03d_%s: %w", m.Version, m.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit revert of migration %03d_%s: %w", m.Version, m.Name, err)
+	}
+	return nil
+}
+
+// beginMigration starts a migration's transaction. On SQLite it runs on its
+// own connection with foreign keys off, since rebuilding a table (create a
+// copy, move the rows, drop the original) trips them halfway, and rows left
+// dangling by older versions would stop the copy altogether. The pragma
+// can't change inside a transaction, so done turns them back on once the
+// transaction has ended.
+func (s *sqlStore) beginMigration() (*storeTx, func(), error) {
+	if s.dialect.name != "sqlite" {
+		tx, err := s.begin()
+		return tx, func() {}, err
+	}
+
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	done := func() {
+		if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`); err != nil {
+			log.Printf("Error re-enabling foreign keys: %v", err)
+			// Don't hand a connection without foreign keys back to the pool.
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+		if err := conn.Close(); err != nil {
+			log.Printf("Error closing connection: %v", err)
 		}
+	}
 
-		log.Printf("Applied migration: %s", entry.Name())
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		done()
+		return nil, nil, err
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		done()
+		return nil, nil, err
 	}
+	return &storeTx{Tx: tx, store: s}, done, nil
+}
 
+// foreignKeyViolations counts the rows per table that point at rows which
+// don't exist. PostgreSQL enforces foreign keys even inside migrations, so
+// there is nothing to count there.
+func (tx *storeTx) foreignKeyViolations() (map[string]int, error) {
+	violations := make(map[string]int)
+	if tx.store.dialect.name != "sqlite" {
+		return violations, nil
+	}
+
+	rows, err := tx.query(`SELECT "table", COUNT(*) FROM pragma_foreign_key_check GROUP BY "table"`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+	for rows.Next() {
+		var table string
+		var count int
+		if err := rows.Scan(&table, &count); err != nil {
+			return nil, err
+		}
+		violations[table] = count
+	}
+	return violations, rows.Err()
+}
+
+// checkForeignKeys fails when a table has more foreign key violations than
+// before. Violations that were already there are left for CheckIntegrity to
+// report, so an old database can still be migrated.
+func (tx *storeTx) checkForeignKeys(before map[string]int) error {
+	after, err := tx.foreignKeyViolations()
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for table, count := range after {
+		if count > before[table] {
+			problems = append(problems, fmt.Sprintf("%s has %d new violations", table, count-before[table]))
+		}
+	}
+	if len(problems) > 0 {
+		slices.Sort(problems)
+		return errors.New(strings.Join(problems, "; "))
+	}
 	return nil
 }
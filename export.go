@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// UserExport is everything the bot stores about a user. Exports made by an
+// admin for another member are limited to the admin's guild and leave out
+// the account and buddy, which are not tied to any guild.
+type UserExport struct {
+	UserID        string               `json:"user_id"`
+	GuildID       string               `json:"guild_id,omitempty"`
+	ExportedAt    time.Time            `json:"exported_at"`
+	Account       *UserAccount         `json:"account,omitempty"`
+	Buddy         string               `json:"buddy,omitempty"`
+	Registrations []RegistrationRecord `json:"registrations"`
+	Commits       []CommitRecord       `json:"commits"`
+	Streaks       []StreakRecord       `json:"streaks"`
+	Challenges    []ChallengeRecord    `json:"challenges"`
+	Achievements  []AchievementRecord  `json:"achievements"`
+}
+
+type AchievementRecord struct {
+	Name       string    `json:"name"`
+	UnlockedAt time.Time `json:"unlocked_at"`
+}
+
+func handleExportCommand(ctx *CommandContext) error {
+	userID := ctx.User.ID
+	guildID := ""
+	if targetID := ctx.OptionID("user"); targetID != "" && targetID != userID {
+		if ctx.GuildID == "" {
+			return ctx.Errorf("command.guild_only")
+		}
+		if !isGuildAdmin(ctx) {
+			return ctx.Errorf("export.not_admin")
+		}
+		userID = targetID
+		guildID = ctx.GuildID
+	}
+
+	format := ctx.StringOption("format")
+	if format == "" {
+		format = "json"
+	}
+
+	export, err := buildUserExport(ctx.DB, userID, guildID)
+	if err != nil {
+		return ctx.Errorf("export.error", err)
+	}
+
+	var data []byte
+	switch format {
+	case "csv":
+		data, err = export.CSV()
+	default:
+		data, err = json.MarshalIndent(export, "", "  ")
+	}
+	if err != nil {
+		return ctx.Errorf("export.error", err)
+	}
+
+	channel, err := ctx.Session.UserChannelCreate(ctx.User.ID)
+	if err != nil {
+		log.Printf("Error opening DM with user %s: %v", ctx.User.ID, err)
+		return ctx.Errorf("export.dm_failed")
+	}
+	_, err = ctx.Session.ChannelMessageSendComplex(channel.ID, &discordgo.MessageSend{
+		Content: tr(ctx.Locale, "export.dm", userID),
+		Files: []*discordgo.File{{
+			Name:        fmt.Sprintf("export-%s.%s", userID, format),
+			ContentType: exportContentType(format),
+			Reader:      bytes.NewReader(data),
+		}},
+	})
+	if err != nil {
+		log.Printf("Error sending export to user %s: %v", ctx.User.ID, err)
+		return ctx.Errorf("export.dm_failed")
+	}
+
+	if guildID != "" {
+		auditAdminAction(ctx, "export", fmt.Sprintf("user=%s format=%s", userID, format))
+	}
+	return ctx.Replyf("export.sent")
+}
+
+func exportContentType(format string) string {
+	if format == "csv" {
+		return "text/csv"
+	}
+	return "application/json"
+}
+
+// buildUserExport gathers a user's data, in one guild or in all of them when
+// guildID is empty.
+func buildUserExport(db Store, userID, guildID string) (UserExport, error) {
+	export := UserExport{UserID: userID, GuildID: guildID, ExportedAt: time.Now().UTC()}
+	var err error
+
+	if guildID == "" {
+		account, err := db.GetUserAccount(userID)
+		if err != nil {
+			return export, err
+		}
+		export.Account = &account
+
+		if export.Buddy, err = db.GetBuddyID(userID); err != nil {
+			return export, err
+		}
+	}
+
+	if export.Registrations, err = db.GetUserRegistrationRecords(userID, guildID); err != nil {
+		return export, err
+	}
+	if export.Commits, err = db.GetUserCommitRecords(userID, guildID); err != nil {
+		return export, err
+	}
+	if export.Streaks, err = db.GetUserStreakRecords(userID, guildID); err != nil {
+		return export, err
+	}
+	if export.Challenges, err = db.GetUserChallengeRecords(userID, guildID); err != nil {
+		return export, err
+	}
+
+	achievements, err := db.GetUserAchievements(userID)
+	if err != nil {
+		return export, err
+	}
+	for name, unlockedAt := range achievements {
+		export.Achievements = append(export.Achievements, AchievementRecord{Name: name, UnlockedAt: unlockedAt})
+	}
+	slices.SortFunc(export.Achievements, func(a, b AchievementRecord) int {
+		return a.UnlockedAt.Compare(b.UnlockedAt)
+	})
+
+	return export, nil
+}
+
+// CSV flattens the export into one table with a row per record. The section
+// column says what kind of record a row is.
+func (e UserExport) CSV() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	rows := [][]string{{"section", "guild_id", "repo", "name", "value", "timestamp"}}
+	if e.Account != nil {
+		rows = append(rows,
+			[]string{"account", "", "", "github_login", e.Account.GithubLogin, e.Account.CreatedAt},
+			[]string{"account", "", "", "has_github_token", strconv.FormatBool(e.Account.HasGithubToken), e.Account.TokenRevokedAt},
+		)
+	}
+	if e.Buddy != "" {
+		rows = append(rows, []string{"buddy", "", "", "user_id", e.Buddy, ""})
+	}
+	for _, r := range e.Registrations {
+		rows = append(rows, []string{"registration", r.GuildID, r.Repo, "channel_id", r.ChannelID, r.RegisteredAt})
+	}
+	for _, c := range e.Commits {
+		rows = append(rows, []string{"commit", "", c.Repo, c.SHA, c.Message, c.CommittedAt})
+	}
+	for _, s := range e.Streaks {
+		rows = append(rows,
+			[]string{"streak", s.GuildID, "", "current", strconv.Itoa(s.Current), s.LastActiveDay},
+			[]string{"streak", s.GuildID, "", "longest", strconv.Itoa(s.Longest), s.LastActiveDay},
+		)
+	}
+	for _, c := range e.Challenges {
+		value := strconv.Itoa(c.Points)
+		if c.Eliminated {
+			value = "eliminated"
+		}
+		rows = append(rows, []string{"challenge", c.GuildID, "", c.Name, value, c.JoinedAt})
+	}
+	for _, a := range e.Achievements {
+		rows = append(rows, []string{"achievement", "", "", a.Name, "", a.UnlockedAt.Format(time.RFC3339)})
+	}
+
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// In GitHub App mode users install the app on their repos instead of
+// authorizing an OAuth token, and the app's single webhook delivers push and
+// installation events for every installed repo.
+
+var githubAppKey *rsa.PrivateKey
+
+func githubAppEnabled() bool {
+	return GithubAppID != ""
+}
+
+func loadGithubAppKey(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("no PEM block found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		githubAppKey = key
+		return nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return errors.New("private key is not an RSA key")
+	}
+	githubAppKey = key
+	return nil
+}
+
+// githubAppJWT signs the short-lived RS256 JWT that authenticates as the app
+// itself. iat is backdated to allow for clock drift, as GitHub recommends.
+func githubAppJWT(now time.Time) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]any{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": GithubAppID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, githubAppKey, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+type installationToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+var (
+	installationTokens   = make(map[int64]installationToken)
+	installationTokensMu sync.Mutex
+)
+
+// getInstallationToken returns a cached installation token, requesting a
+// new one a minute before the cached one expires.
+func getInstallationToken(installationID int64) (string, error) {
+	installationTokensMu.Lock()
+	cached, ok := installationTokens[installationID]
+	installationTokensMu.Unlock()
+	if ok && time.Until(cached.expiresAt) > time.Minute {
+		return cached.token, nil
+	}
+
+	jwt, err := githubAppJWT(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST",
+		fmt.Sprintf("https://api.github.com/app/installations/%d/access_tokens", installationID),
+		nil,
+	)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			log.Printf("Error closing response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("failed to create installation token, status: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+
+	installationTokensMu.Lock()
+	installationTokens[installationID] = installationToken{token: result.Token, expiresAt: result.ExpiresAt}
+	installationTokensMu.Unlock()
+	return result.Token, nil
+}
+
+// repoInstallationToken returns an installation token for the repo, or ""
+// when the app is not installed on it.
+func repoInstallationToken(db Store, owner, repo string) (string, error) {
+	installationID, _, err := db.GetRepoInstallation(owner, repo)
+	if err != nil || installationID == 0 {
+		return "", err
+	}
+	return getInstallationToken(installationID)
+}
+
+func listInstallationRepos(installationID int64) ([]string, error) {
+	token, err := getInstallationToken(installationID)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for page := 1; ; page++ {
+		req, err := http.NewRequest("GET",
+			fmt.Sprintf("https://api.github.com/installation/repositories?per_page=100&page=%d", page),
+			nil,
+		)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept", "application/vnd.github+json")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			TotalCount   int `json:"total_count"`
+			Repositories []struct {
+				FullName string `json:"full_name"`
+			} `json:"repositories"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to list installation repos, status: %d", resp.StatusCode)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range result.Repositories {
+			names = append(names, r.FullName)
+		}
+		if len(result.Repositories) == 0 || len(names) >= result.TotalCount {
+			return names, nil
+		}
+	}
+}
+
+// syncInstallation records the installation and which repos it covers.
+func syncInstallation(db Store, installationID int64, account string) error {
+	names, err := listInstallationRepos(installationID)
+	if err != nil {
+		return err
+	}
+	if err := db.UpsertInstallation(installationID, account); err != nil {
+		return err
+	}
+	if err := db.ClearInstallationRepos(installationID); err != nil {
+		return err
+	}
+	for _, name := range names {
+		owner, repo, _ := strings.Cut(name, "/")
+		if err := db.SetRepoInstallation(owner, repo, installationID); err != nil {
+			return err
+		}
+	}
+	log.Printf("Synced installation %d (%s) with %d repos", installationID, account, len(names))
+	return nil
+}
+
+type installationPayload struct {
+	Action       string `json:"action"`
+	Installation struct {
+		ID      int64 `json:"id"`
+		Account struct {
+			Login string `json:"login"`
+		} `json:"account"`
+	} `json:"installation"`
+	RepositoriesAdded []struct {
+		FullName string `json:"full_name"`
+	} `json:"repositories_added"`
+	RepositoriesRemoved []struct {
+		FullName string `json:"full_name"`
+	} `json:"repositories_removed"`
+}
+
+// handleInstallationEvent keeps the installed repos in sync from the
+// installation and installation_repositories webhook events.
+func handleInstallationEvent(db Store, event string, body []byte) error {
+	var payload installationPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return err
+	}
+	id := payload.Installation.ID
+	log.Printf("Received %s event (%s) for installation %d", event, payload.Action, id)
+
+	if event == "installation_repositories" {
+		for _, r := range payload.RepositoriesAdded {
+			owner, repo, _ := strings.Cut(r.FullName, "/")
+			if err := db.SetRepoInstallation(owner, repo, id); err != nil {
+				return err
+			}
+		}
+		for _, r := range payload.RepositoriesRemoved {
+			owner, repo, _ := strings.Cut(r.FullName, "/")
+			if err := db.SetRepoInstallation(owner, repo, 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch payload.Action {
+	case "deleted":
+		return db.DeleteInstallation(id)
+	case "suspend":
+		// Keep the repos so unsuspending picks up where it left off.
+		return db.SetInstallationSuspended(id, true)
+	case "unsuspend":
+		if err := db.SetInstallationSuspended(id, false); err != nil {
+			return err
+		}
+		return syncInstallation(db, id, payload.Installation.Account.Login)
+	default:
+		return syncInstallation(db, id, payload.Installation.Account.Login)
+	}
+}
+
+// registerWithGitHubApp registers the repo right away when the app is
+// installed on it, and otherwise links to the installation page. GitHub
+// passes state back to /github/setup once the app is installed.
+func registerWithGitHubApp(ctx *CommandContext, owner, repo string) error {
+	installationID, fullName, err := ctx.DB.GetRepoInstallation(owner, repo)
+	if err != nil {
+		return err
+	}
+
+	if installationID != 0 {
+		owner, repo, _ = strings.Cut(fullName, "/")
+		registered, err := ctx.DB.IsRepoRegistered(ctx.User.ID, owner, repo, ctx.GuildID)
+		if err != nil {
+			return err
+		}
+		if registered {
+			return ctx.Errorf("register.already_registered", fullName)
+		}
+
+		ok, err := userCanSeeRepo(ctx.DB, ctx.User.ID, owner, repo)
+		if err != nil {
+			return ctx.Errorf("register.lookup_error", err)
+		}
+		if !ok {
+			authURL, err := repoAccessAuthURL(ctx.DB, PendingAuth{
+				DiscordUserID:    ctx.User.ID,
+				GuildID:          ctx.GuildID,
+				Owner:            owner,
+				Repo:             repo,
+				ChannelID:        ctx.Interaction.ChannelID,
+				Locale:           ctx.Locale,
+				InteractionToken: ctx.Interaction.Token,
+			})
+			if err != nil {
+				return err
+			}
+			if authURL == "" {
+				return ctx.Errorf("register.not_found", fullName)
+			}
+			return ctx.Replyf("register.verify_access", fullName, authURL)
+		}
+		if err := ctx.DB.RegisterRepo(ctx.User.ID, owner, repo, ctx.GuildID, ctx.Interaction.ChannelID); err != nil {
+			return ctx.Errorf("register.error", err)
+		}
+		announceRegistration(ctx.DB, ctx.Session, ctx.User.ID, owner, repo, ctx.GuildID, ctx.Interaction.ChannelID)
+		return ctx.Replyf("register.installed", fullName)
+	}
+
+	// Check the repo exists and the user can see it before sending them off
+	// to install the app, the same way registering without the app does.
+	token, err := ctx.DB.GetGithubToken(ctx.User.ID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error getting GitHub token for user %s: %v", ctx.User.ID, err)
+	}
+	fullName, err = getGitHubRepo(token, owner, repo)
+	if errors.Is(err, errRepoNotFound) {
+		return ctx.Errorf("register.not_found", owner+"/"+repo)
+	}
+	if err != nil {
+		return ctx.Errorf("register.lookup_error", err)
+	}
+	owner, repo, _ = strings.Cut(fullName, "/")
+
+	stateToken := generateStateToken()
+	if stateToken == "" {
+		return fmt.Errorf("error generating install state")
+	}
+	err = ctx.DB.CreatePendingAuth(stateToken, PendingAuth{
+		DiscordUserID:    ctx.User.ID,
+		GuildID:          ctx.GuildID,
+		Owner:            owner,
+		Repo:             repo,
+		ChannelID:        ctx.Interaction.ChannelID,
+		Locale:           ctx.Locale,
+		InteractionToken: ctx.Interaction.Token,
+		ExpiresAt:        time.Now().Add(pendingAuthTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	installURL := fmt.Sprintf("https://github.com/apps/%s/installations/new?state=%s", GithubAppSlug, stateToken)
+	return ctx.Replyf("register.install", owner, repo, installURL)
+}
+
+// userCanSeeRepo checks that the user may track a repo the app is installed
+// on. The installation token sees every installed repo, so the check uses
+// the user's own token, or no token at all for public repos.
+func userCanSeeRepo(db Store, userID, owner, repo string) (bool, error) {
+	token, err := db.GetGithubToken(userID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error getting GitHub token for user %s: %v", userID, err)
+	}
+	_, err = getGitHubRepo(token, owner, repo)
+	if errors.Is(err, errRepoNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// repoAccessAuthURL sends the user through the app's OAuth flow so the
+// callback can check the repo with their token. It returns "" when the app's
+// client ID isn't configured.
+func repoAccessAuthURL(db Store, pending PendingAuth) (string, error) {
+	if GithubClientID == "" {
+		return "", nil
+	}
+
+	state := generateStateToken()
+	codeVerifier := generateCodeVerifier()
+	if state == "" || codeVerifier == "" {
+		return "", fmt.Errorf("error generating OAuth state")
+	}
+	pending.CodeVerifier = codeVerifier
+	pending.ExpiresAt = time.Now().Add(pendingAuthTTL)
+	if err := db.CreatePendingAuth(state, pending); err != nil {
+		return "", err
+	}
+	return oauthAuthorizeURL(state, codeVerifier), nil
+}
+
+// handleGithubAppSetup is the app's setup URL, where GitHub sends the user
+// after installing the app. The installation is synced right away rather
+// than waiting for the webhook so the pending repo can be registered.
+func handleGithubAppSetup(db Store, dg *discordgo.Session, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	locale := acceptLanguageLocale(r.Header.Get("Accept-Language"))
+
+	var installationID int64
+	if _, err := fmt.Sscanf(query.Get("installation_id"), "%d", &installationID); err != nil {
+		renderCallbackPage(w, http.StatusBadRequest, tr(locale, "callback.error_title"), tr(locale, "setup.invalid"))
+		return
+	}
+
+	// This URL is public, so nothing is synced unless it carries the state
+	// of a /register in progress. Installs started elsewhere are recorded
+	// from the signed installation webhook instead.
+	pending, err := db.ConsumePendingAuth(query.Get("state"), time.Now())
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error loading pending auth: %v", err)
+		}
+		renderCallbackPage(w, http.StatusOK, tr(locale, "setup.installed_title"), tr(locale, "setup.installed"))
+		return
+	}
+	if pending.Locale != "" {
+		locale = pending.Locale
+	}
+
+	if err := syncInstallation(db, installationID, ""); err != nil {
+		log.Printf("Error syncing installation %d: %v", installationID, err)
+		updateRegisterReply(dg, pending, tr(pending.Locale, "register.error", err))
+		renderCallbackPage(w, http.StatusInternalServerError, tr(locale, "callback.error_title"), tr(locale, "setup.sync_error"))
+		return
+	}
+
+	id, fullName, err := db.GetRepoInstallation(pending.Owner, pending.Repo)
+	if err != nil || id == 0 {
+		if err != nil {
+			log.Printf("Error getting installation for %s/%s: %v", pending.Owner, pending.Repo, err)
+		}
+		updateRegisterReply(dg, pending, tr(pending.Locale, "register.not_installed", pending.Owner, pending.Repo))
+		renderCallbackPage(w, http.StatusOK, tr(locale, "callback.error_title"), tr(locale, "setup.repo_missing", pending.Owner, pending.Repo))
+		return
+	}
+
+	owner, repo, _ := strings.Cut(fullName, "/")
+
+	// Anyone can open this URL with any installation ID, so installing the
+	// app doesn't prove the user can see the repo.
+	ok, err := userCanSeeRepo(db, pending.DiscordUserID, owner, repo)
+	if err != nil {
+		log.Printf("Error checking access to %s/%s for user %s: %v", owner, repo, pending.DiscordUserID, err)
+		renderCallbackPage(w, http.StatusBadGateway, tr(locale, "callback.error_title"), tr(locale, "callback.lookup_error"))
+		return
+	}
+	if !ok {
+		pending.Owner, pending.Repo = owner, repo
+		authURL, err := repoAccessAuthURL(db, pending)
+		if err != nil {
+			log.Printf("Error creating access check for user %s: %v", pending.DiscordUserID, err)
+		}
+		if authURL != "" {
+			http.Redirect(w, r, authURL, http.StatusFound)
+			return
+		}
+		updateRegisterReply(dg, pending, tr(pending.Locale, "register.no_access", owner, repo))
+		renderCallbackPage(w, http.StatusForbidden, tr(locale, "callback.error_title"), tr(locale, "callback.no_access", owner, repo))
+		return
+	}
+
+	if err := db.RegisterRepo(pending.DiscordUserID, owner, repo, pending.GuildID, pending.ChannelID); err != nil {
+		log.Printf("Error registering repo: %v", err)
+		renderCallbackPage(w, http.StatusInternalServerError, tr(locale, "callback.error_title"), tr(locale, "callback.store_error"))
+		return
+	}
+	announceRegistration(db, dg, pending.DiscordUserID, owner, repo, pending.GuildID, pending.ChannelID)
+
+	renderCallbackPage(w, http.StatusOK, tr(locale, "callback.success_title"), tr(locale, "callback.success_body", owner, repo))
+}
@@ -4,6 +4,7 @@ go 1.24.5
 
 require (
 	github.com/bwmarrin/discordgo v0.29.0
+	github.com/lib/pq v1.9.0
 	modernc.org/sqlite v1.46.1
 )
 
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"strings"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var allFeatures = []string{"reports", "notifications", "achievements", "buddies", "challenges", "streak_roles"}
+
+func (cfg GuildConfig) Enabled(feature string) bool {
+	return slices.Contains(cfg.Features, feature)
+}
+
+func (cfg GuildConfig) Location() *time.Location {
+	if cfg.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		log.Printf("Invalid timezone %q for guild %s: %v", cfg.Timezone, cfg.ID, err)
+		return time.Local
+	}
+	return loc
+}
+
+// dueAt returns when today's check runs in the guild's timezone.
+func (cfg GuildConfig) dueAt(now time.Time) time.Time {
+	local := now.In(cfg.Location())
+	checkTime, err := time.Parse("15:04", cfg.CheckTime)
+	if err != nil {
+		checkTime = time.Date(0, 1, 1, 20, 0, 0, 0, time.UTC)
+	}
+	return time.Date(local.Year(), local.Month(), local.Day(), checkTime.Hour(), checkTime.Minute(), 0, 0, local.Location())
+}
+
+func guildFeatureEnabled(db Store, guildID, feature string) bool {
+	cfg, err := db.GetGuildConfig(guildID)
+	if err != nil {
+		log.Printf("Error getting config for guild %s: %v", guildID, err)
+	}
+	return cfg.Enabled(feature)
+}
+
+// backfillRegistrationGuilds assigns a guild to registrations created before
+// registrations were scoped by guild, using the channel they were made in.
+func backfillRegistrationGuilds(db Store, dg *discordgo.Session) {
+	registrations, err := db.GetRegistrationsWithoutGuild()
+	if err != nil {
+		log.Printf("Error getting registrations without guild: %v", err)
+		return
+	}
+
+	for _, r := range registrations {
+		guildID := guildIDForChannel(dg, r.ChannelID)
+		if guildID == "" {
+			continue
+		}
+		if err := db.SetRegistrationGuild(r.ID, guildID); err != nil {
+			log.Printf("Error backfilling guild for registration %d: %v", r.ID, err)
+		}
+	}
+}
+
+// runGuildCheck posts the daily reports for a guild. Only the scheduled
+// check is final: it settles streaks and challenges for the day, while an
+// admin-triggered run posts a preview that changes nothing. Streaks are
+// settled even when the guild has turned daily reports off. It reports
+// whether challenge evaluation was postponed.
+func runGuildCheck(db Store, dg *discordgo.Session, cfg GuildConfig, now time.Time, final bool) bool {
+	if final || cfg.Enabled("reports") {
+		users, err := db.GetGuildRegisteredUsers(cfg.ID)
+		if err != nil {
+			log.Printf("Error getting registered users for guild %s: %v", cfg.ID, err)
+			return false
+		}
+
+		for _, user := range users {
+			if !cfg.Enabled("reports") {
+				settleUserStreak(db, dg, cfg, user.UserID)
+				continue
+			}
+			channelID := user.ChannelID
+			if cfg.ReportChannelID != "" {
+				channelID = cfg.ReportChannelID
+			}
+			processUserCommits(db, dg, cfg.ID, user.UserID, channelID, final)
+		}
+	}
+
+	if final && cfg.Enabled("challenges") {
+		return evaluateChallenges(db, dg, cfg.ID, now.In(cfg.Location()))
+	}
+	return false
+}
+
+func handleConfigCommand(ctx *CommandContext) error {
+	switch ctx.Subcommand {
+	case "channel":
+		channelID := ""
+		if channel := ctx.ChannelOption("channel"); channel != nil {
+			channelID = channel.ID
+		}
+		if err := ctx.DB.SetGuildReportChannel(ctx.GuildID, channelID); err != nil {
+			return ctx.Errorf("config.save_error", err)
+		}
+		if channelID == "" {
+			return ctx.Replyf("config.channel_cleared")
+		}
+		return ctx.Replyf("config.channel_set", channelID)
+
+	case "schedule":
+		timezone := ctx.StringOption("timezone")
+		checkTime := ctx.StringOption("time")
+		if _, err := time.LoadLocation(timezone); err != nil {
+			return ctx.Errorf("config.invalid_timezone", timezone)
+		}
+		if _, err := time.Parse("15:04", checkTime); err != nil {
+			return ctx.Errorf("config.invalid_time")
+		}
+		if err := ctx.DB.SetGuildSchedule(ctx.GuildID, timezone, checkTime); err != nil {
+			return ctx.Errorf("config.save_error", err)
+		}
+		return ctx.Replyf("config.schedule_set", checkTime, timezone)
+
+	case "feature":
+		feature := ctx.StringOption("name")
+		enabled := ctx.BoolOption("enabled")
+
+		cfg, err := ctx.DB.GetGuildConfig(ctx.GuildID)
+		if err != nil {
+			return ctx.Errorf("config.load_error", err)
+		}
+		features := slices.DeleteFunc(slices.Clone(cfg.Features), func(f string) bool { return f == feature })
+		if enabled {
+			features = append(features, feature)
+		}
+		if err := ctx.DB.SetGuildFeatures(ctx.GuildID, features); err != nil {
+			return ctx.Errorf("config.save_error", err)
+		}
+		if enabled {
+			return ctx.Replyf("config.feature_enabled", feature)
+		}
+		return ctx.Replyf("config.feature_disabled", feature)
+
+	case "show":
+		cfg, err := ctx.DB.GetGuildConfig(ctx.GuildID)
+		if err != nil {
+			return ctx.Errorf("config.load_error", err)
+		}
+
+		channel := tr(ctx.Locale, "config.channel_default")
+		if cfg.ReportChannelID != "" {
+			channel = "<#" + cfg.ReportChannelID + ">"
+		}
+		timezone := cfg.Timezone
+		if timezone == "" {
+			timezone = time.Local.String()
+		}
+		return ctx.Replyf("config.show", channel, cfg.CheckTime, timezone, strings.Join(cfg.Features, ", "))
+	}
+	return nil
+}
+
+func handleLeaderboardCommand(ctx *CommandContext) error {
+	streaks, err := ctx.DB.GetGuildStreaks(ctx.GuildID, 10)
+	if err != nil {
+		return ctx.Errorf("leaderboard.load_error", err)
+	}
+	if len(streaks) == 0 {
+		return ctx.Replyf("leaderboard.empty")
+	}
+
+	var sb strings.Builder
+	sb.WriteString(tr(ctx.Locale, "leaderboard.header") + "\n")
+	for rank, st := range streaks {
+		sb.WriteString(tr(ctx.Locale, "leaderboard.entry", rank+1, st.UserID, st.Current, st.Longest) + "\n")
+	}
+	return ctx.Reply(sb.String())
+}
+
+func featureChoices() []*discordgo.ApplicationCommandOptionChoice {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, feature := range allFeatures {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: feature, Value: feature})
+	}
+	return choices
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"html/template"
 	"io"
 	"log"
 	"net/http"
@@ -12,13 +13,18 @@ import (
 	"github.com/bwmarrin/discordgo"
 )
 
+type PushCommit struct {
+	ID        string `json:"id"`
+	Message   string `json:"message"`
+	Timestamp string `json:"timestamp"`
+	Author    struct {
+		Name     string `json:"name"`
+		Username string `json:"username"`
+	} `json:"author"`
+}
+
 type PushPayload struct {
-	Commits []struct {
-		Message string `json:"message"`
-		Author  struct {
-			Name string `json:"name"`
-		} `json:"author"`
-	} `json:"commits"`
+	Commits    []PushCommit `json:"commits"`
 	Repository struct {
 		Name  string `json:"name"`
 		Owner struct {
@@ -33,7 +39,7 @@ type CommitResponse []struct {
 	} `json:"commit"`
 }
 
-func handleWebhook(db *sql.DB, dg *discordgo.Session, w http.ResponseWriter, r *http.Request) {
+func handleWebhook(db Store, dg *discordgo.Session, w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
@@ -60,6 +66,44 @@ func handleWebhook(db *sql.DB, dg *discordgo.Session, w http.ResponseWriter, r *
 	}
 	log.Printf("Signature verified successfully")
 
+	switch event := r.Header.Get("X-GitHub-Event"); event {
+	case "installation", "installation_repositories":
+		if err := handleInstallationEvent(db, event, body); err != nil {
+			log.Printf("Error handling %s event: %v", event, err)
+			http.Error(w, "Error handling event", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	case "github_app_authorization":
+		var payload struct {
+			Action string `json:"action"`
+			Sender struct {
+				Login string `json:"login"`
+			} `json:"sender"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if payload.Action == "revoked" {
+			userIDs, err := db.GetUserIDsByGithubLogin(payload.Sender.Login)
+			if err != nil {
+				log.Printf("Error getting users for GitHub login %s: %v", payload.Sender.Login, err)
+			}
+			for _, userID := range userIDs {
+				handleRevokedToken(db, dg, userID)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	case "", "push":
+	default:
+		log.Printf("Ignoring %s event", event)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
 	var payload PushPayload
 	if err := json.Unmarshal(body, &payload); err != nil {
 		log.Printf("Error parsing JSON: %v", err)
@@ -75,69 +119,162 @@ func handleWebhook(db *sql.DB, dg *discordgo.Session, w http.ResponseWriter, r *
 	owner := payload.Repository.Owner.Login
 	repo := payload.Repository.Name
 
-	users, err := getUserIDsByRepo(db, owner, repo)
+	// GitHub only knows the author's login when the commit email belongs to
+	// an account. Other commits are stored without an author and credited
+	// to nobody, rather than to whoever happened to push them.
+	if err := db.StoreCommits(owner, repo, payload.Commits); err != nil {
+		log.Printf("Error storing commits for %s/%s: %v", owner, repo, err)
+	}
+
+	users, err := db.GetUserIDsByRepo(owner, repo)
 	if err != nil {
 		log.Printf("Error getting user ID by Repo: %v", err)
 	}
 	log.Printf("Found %d users subscribed to repo %s/%s", len(users), owner, repo)
 
 	for _, user := range users {
-		sendMessage(dg, user.ChannelID, fmt.Sprintf("<@%s> New commit by %s in repo %s: %s", user.UserID, owner, repo, payload.Commits[0].Message))
-		log.Printf("Sent message to user %s for repo %s/%s in channel %s", user.UserID, owner, repo, user.ChannelID)
+		cfg, err := db.GetGuildConfig(user.GuildID)
+		if err != nil {
+			log.Printf("Error getting config for guild %s: %v", user.GuildID, err)
+		}
+
+		if cfg.Enabled("notifications") {
+			sendMessage(dg, user.ChannelID, renderMessage(db, dg, user.GuildID, "new_commit", MessageData{
+				User:    fmt.Sprintf("<@%s>", user.UserID),
+				Owner:   owner,
+				Repo:    repo,
+				Message: payload.Commits[0].Message,
+			}))
+			log.Printf("Sent message to user %s for repo %s/%s in channel %s", user.UserID, owner, repo, user.ChannelID)
+		}
+
+		if cfg.Enabled("achievements") {
+			announceAchievements(db, dg, user.UserID, user.GuildID, user.ChannelID, payload.Commits)
+		}
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
-func handleGithubCallback(db *sql.DB, dg *discordgo.Session, w http.ResponseWriter, r *http.Request) {
-	code := r.URL.Query().Get("code")
-	state := r.URL.Query().Get("state")
+var callbackPage = template.Must(template.New("callback").Parse(`<!DOCTYPE html>
+<html>
+    <head>
+        <meta charset="utf-8">
+        <title>{{.Title}}</title>
+    </head>
+    <body style="font-family: sans-serif; text-align: center; padding: 40px;">
+        <h2>{{.Title}}</h2>
+        <p>{{.Body}}</p>
+    </body>
+</html>
+`))
+
+func renderCallbackPage(w http.ResponseWriter, status int, title, body string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := callbackPage.Execute(w, struct{ Title, Body string }{title, body}); err != nil {
+		log.Printf("Error rendering callback page: %v", err)
+	}
+}
+
+// updateRegisterReply replaces the ephemeral /register reply, whose link is
+// now spent, with content.
+func updateRegisterReply(dg *discordgo.Session, pending PendingAuth, content string) {
+	if pending.InteractionToken == "" {
+		return
+	}
+	interaction := &discordgo.Interaction{AppID: dg.State.User.ID, Token: pending.InteractionToken}
+	if _, err := dg.InteractionResponseEdit(interaction, &discordgo.WebhookEdit{Content: &content}); err != nil {
+		log.Printf("Error updating register reply for user %s: %v", pending.DiscordUserID, err)
+	}
+}
 
-	pendingAuthsMu.Lock()
-	pending, ok := pendingAuths[state]
-	delete(pendingAuths, state)
-	pendingAuthsMu.Unlock()
+func handleGithubCallback(db Store, dg *discordgo.Session, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	code := query.Get("code")
+	state := query.Get("state")
 
-	if !ok || time.Now().After(pending.ExpiresAt) {
-		http.Error(w, "Invalid or expired state parameter", http.StatusBadRequest)
+	locale := acceptLanguageLocale(r.Header.Get("Accept-Language"))
+	pending, err := db.ConsumePendingAuth(state, time.Now())
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error loading pending auth: %v", err)
+		}
+		renderCallbackPage(w, http.StatusBadRequest, tr(locale, "callback.invalid_state_title"), tr(locale, "callback.invalid_state"))
 		return
 	}
+	if pending.Locale != "" {
+		locale = pending.Locale
+	}
 
-	accessToken, err := exchangeCodeForToken(code)
+	if oauthErr := query.Get("error"); oauthErr != "" {
+		if oauthErr == "access_denied" {
+			log.Printf("User %s denied GitHub access for %s/%s", pending.DiscordUserID, pending.Owner, pending.Repo)
+			updateRegisterReply(dg, pending, tr(pending.Locale, "register.denied", pending.Owner, pending.Repo))
+			renderCallbackPage(w, http.StatusOK, tr(locale, "callback.denied_title"), tr(locale, "callback.denied"))
+			return
+		}
+		log.Printf("GitHub OAuth error for user %s: %s - %s", pending.DiscordUserID, oauthErr, query.Get("error_description"))
+		updateRegisterReply(dg, pending, tr(pending.Locale, "register.oauth_error", pending.Owner, pending.Repo))
+		renderCallbackPage(w, http.StatusBadRequest, tr(locale, "callback.error_title"), tr(locale, "callback.token_error"))
+		return
+	}
+
+	accessToken, err := exchangeCodeForToken(code, pending.CodeVerifier)
 	if err != nil {
 		log.Printf("Error exchanging code for token: %v", err)
-		http.Error(w, "Error exchanging code for token", http.StatusInternalServerError)
+		renderCallbackPage(w, http.StatusInternalServerError, tr(locale, "callback.error_title"), tr(locale, "callback.token_error"))
 		return
 	}
 
-	err = storesGithubToken(db, pending.DiscordUserID, accessToken)
+	login, _, err := getGitHubUser(accessToken)
+	if err != nil {
+		log.Printf("Error getting GitHub user for user %s: %v", pending.DiscordUserID, err)
+	}
+
+	err = db.StoreGithubToken(pending.DiscordUserID, accessToken, login)
 	if err != nil {
 		log.Printf("Error storing GitHub token: %v", err)
-		http.Error(w, "Error storing GitHub token", http.StatusInternalServerError)
+		renderCallbackPage(w, http.StatusInternalServerError, tr(locale, "callback.error_title"), tr(locale, "callback.store_error"))
 		return
 	}
-	webhookURL := fmt.Sprintf("%s/webhook", BaseURL)
-	err = createWebhook(db, accessToken, pending.Owner, pending.Repo, webhookURL)
-	if err != nil {
-		log.Printf("Error creating GitHub webhook: %v", err)
-		http.Error(w, "Error creating GitHub webhook", http.StatusInternalServerError)
+
+	// Re-authorization after a revoked token has no repo attached.
+	if pending.Owner == "" {
+		log.Printf("Restored GitHub access for user %s", pending.DiscordUserID)
+		renderCallbackPage(w, http.StatusOK, tr(locale, "callback.success_title"), tr(locale, "callback.reauth_success"))
 		return
 	}
 
-	err = registerRepo(db, pending.DiscordUserID, pending.Owner, pending.Repo, pending.ChannelID)
-	if err != nil {
-		log.Printf("Error registering repo: %v", err)
+	// In GitHub App mode the authorization only proves which repos the
+	// user can see; the app's installation already delivers the events.
+	if githubAppEnabled() {
+		ok, err := userCanSeeRepo(db, pending.DiscordUserID, pending.Owner, pending.Repo)
+		if err != nil {
+			log.Printf("Error checking access to %s/%s for user %s: %v", pending.Owner, pending.Repo, pending.DiscordUserID, err)
+			renderCallbackPage(w, http.StatusBadGateway, tr(locale, "callback.error_title"), tr(locale, "callback.lookup_error"))
+			return
+		}
+		if !ok {
+			updateRegisterReply(dg, pending, tr(pending.Locale, "register.no_access", pending.Owner, pending.Repo))
+			renderCallbackPage(w, http.StatusForbidden, tr(locale, "callback.error_title"), tr(locale, "callback.no_access", pending.Owner, pending.Repo))
+			return
+		}
+		if err := db.RegisterRepo(pending.DiscordUserID, pending.Owner, pending.Repo, pending.GuildID, pending.ChannelID); err != nil {
+			log.Printf("Error registering repo: %v", err)
+			renderCallbackPage(w, http.StatusInternalServerError, tr(locale, "callback.error_title"), tr(locale, "callback.store_error"))
+			return
+		}
+		announceRegistration(db, dg, pending.DiscordUserID, pending.Owner, pending.Repo, pending.GuildID, pending.ChannelID)
+	} else {
+		err = completeRegistration(db, dg, accessToken, pending.DiscordUserID, pending.Owner, pending.Repo, pending.GuildID, pending.ChannelID)
+		if err != nil {
+			log.Printf("Error completing registration: %v", err)
+			renderCallbackPage(w, http.StatusInternalServerError, tr(locale, "callback.error_title"), tr(locale, "callback.webhook_error"))
+			return
+		}
 	}
-	log.Printf("Registered repo %s/%s for user %s in channel %s", pending.Owner, pending.Repo, pending.DiscordUserID, pending.ChannelID)
-	sendMessage(dg, pending.ChannelID, fmt.Sprintf("<@%s> Successfully registered repo %s/%s for tracking!", pending.DiscordUserID, pending.Owner, pending.Repo))
 
 	log.Printf("Successfully authenticated user %s for repo %s/%s", pending.DiscordUserID, pending.Owner, pending.Repo)
 
-	fmt.Fprintf(w, `
-    <html>
-        <body style="font-family: sans-serif; text-align: center; padding: 40px;">
-            <h2>✅ Success!</h2>
-            <p>%s/%s is now being tracked. You can close this tab and return to Discord.</p>
-        </body>
-    </html>
-`, pending.Owner, pending.Repo)
+	renderCallbackPage(w, http.StatusOK, tr(locale, "callback.success_title"), tr(locale, "callback.success_body", pending.Owner, pending.Repo))
 }
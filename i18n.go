@@ -0,0 +1,176 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//go:embed locales/*.json
+var localeFS embed.FS
+
+const defaultLocale = discordgo.EnglishUS
+
+var catalogs = mustLoadCatalogs()
+
+func mustLoadCatalogs() map[discordgo.Locale]map[string]string {
+	entries, err := localeFS.ReadDir("locales")
+	if err != nil {
+		panic(fmt.Sprintf("failed to read embedded locales: %v", err))
+	}
+
+	result := make(map[discordgo.Locale]map[string]string)
+	for _, entry := range entries {
+		data, err := localeFS.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(fmt.Sprintf("failed to read locale %s: %v", entry.Name(), err))
+		}
+
+		var catalog map[string]string
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			panic(fmt.Sprintf("failed to parse locale %s: %v", entry.Name(), err))
+		}
+		result[discordgo.Locale(strings.TrimSuffix(entry.Name(), ".json"))] = catalog
+	}
+
+	if _, ok := result[defaultLocale]; !ok {
+		panic(fmt.Sprintf("missing default locale %s", defaultLocale))
+	}
+	return result
+}
+
+// resolveLocale maps any Discord locale onto a catalog we ship, preferring an
+// exact match, then one sharing the same language (es-419 -> es-ES), then the
+// default.
+func resolveLocale(locale discordgo.Locale) discordgo.Locale {
+	if _, ok := catalogs[locale]; ok {
+		return locale
+	}
+
+	lang, _, _ := strings.Cut(string(locale), "-")
+	for candidate := range catalogs {
+		candidateLang, _, _ := strings.Cut(string(candidate), "-")
+		if lang != "" && candidateLang == lang {
+			return candidate
+		}
+	}
+	return defaultLocale
+}
+
+func lookup(locale discordgo.Locale, key string) (string, bool) {
+	if text, ok := catalogs[resolveLocale(locale)][key]; ok {
+		return text, true
+	}
+	text, ok := catalogs[defaultLocale][key]
+	return text, ok
+}
+
+func tr(locale discordgo.Locale, key string, args ...any) string {
+	text, ok := lookup(locale, key)
+	if !ok {
+		log.Printf("Missing translation for key %s", key)
+		return key
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+func supportedLocale(locale string) bool {
+	_, ok := catalogs[discordgo.Locale(locale)]
+	return ok
+}
+
+// guildLocale is the language used for messages posted to a guild's channels.
+// An explicit /language setting wins over the guild's Discord preference.
+func guildLocale(db Store, dg *discordgo.Session, guildID string) discordgo.Locale {
+	locale, err := db.GetGuildLocale(guildID)
+	if err != nil {
+		log.Printf("Error getting locale for guild %s: %v", guildID, err)
+	}
+	if locale != "" {
+		return discordgo.Locale(locale)
+	}
+
+	if guild, err := dg.State.Guild(guildID); err == nil && guild.PreferredLocale != "" {
+		return resolveLocale(discordgo.Locale(guild.PreferredLocale))
+	}
+	return defaultLocale
+}
+
+// acceptLanguageLocale picks the first language from an Accept-Language header
+// for pages rendered outside of Discord, such as the OAuth callback.
+func acceptLanguageLocale(header string) discordgo.Locale {
+	first, _, _ := strings.Cut(header, ",")
+	first, _, _ = strings.Cut(first, ";")
+	return resolveLocale(discordgo.Locale(strings.TrimSpace(first)))
+}
+
+// localizeCommands fills in name and description localizations for every
+// command, subcommand and option from the catalogs, using the keys
+// cmd.<path>.name and cmd.<path>.description.
+func localizeCommands(cmds []*discordgo.ApplicationCommand) {
+	for _, cmd := range cmds {
+		names, descriptions := commandLocalizations(cmd.Name)
+		cmd.NameLocalizations = &names
+		cmd.DescriptionLocalizations = &descriptions
+		localizeOptions(cmd.Name, cmd.Options)
+	}
+}
+
+func localizeOptions(prefix string, options []*discordgo.ApplicationCommandOption) {
+	for _, opt := range options {
+		keyPath := prefix + "." + opt.Name
+		opt.NameLocalizations, opt.DescriptionLocalizations = commandLocalizations(keyPath)
+		localizeOptions(keyPath, opt.Options)
+	}
+}
+
+func commandLocalizations(keyPath string) (names, descriptions map[discordgo.Locale]string) {
+	names = make(map[discordgo.Locale]string)
+	descriptions = make(map[discordgo.Locale]string)
+
+	for locale, catalog := range catalogs {
+		if locale == defaultLocale {
+			continue
+		}
+		if name, ok := catalog["cmd."+keyPath+".name"]; ok {
+			names[locale] = name
+		}
+		if description, ok := catalog["cmd."+keyPath+".description"]; ok {
+			descriptions[locale] = description
+		}
+	}
+	return names, descriptions
+}
+
+func handleLanguageCommand(ctx *CommandContext) error {
+	locale := ctx.StringOption("locale")
+	if !supportedLocale(locale) {
+		return ctx.Errorf("language.unsupported", locale)
+	}
+
+	if err := ctx.DB.SetGuildLocale(ctx.GuildID, locale); err != nil {
+		return ctx.Errorf("language.save_error", err)
+	}
+	return ctx.Reply(tr(discordgo.Locale(locale), "language.set", discordgo.Locales[discordgo.Locale(locale)]))
+}
+
+func languageChoices() []*discordgo.ApplicationCommandOptionChoice {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for locale := range catalogs {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  discordgo.Locales[locale],
+			Value: string(locale),
+		})
+	}
+	sort.Slice(choices, func(a, b int) bool { return choices[a].Value.(string) < choices[b].Value.(string) })
+	return choices
+}
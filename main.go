@@ -1,10 +1,10 @@
 package main
 
 import (
-	"database/sql"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
@@ -15,10 +15,42 @@ var (
 	GithubSecret   = os.Getenv("GITHUB_CLIENT_SECRET")
 	BaseURL        = os.Getenv("BASE_URL")
 	WebhookSecret  = os.Getenv("WEBHOOK_SECRET")
+	DevGuildID     = os.Getenv("DEV_GUILD_ID")
+
+	// Setting GITHUB_APP_ID switches to GitHub App mode.
+	GithubAppID      = os.Getenv("GITHUB_APP_ID")
+	GithubAppSlug    = os.Getenv("GITHUB_APP_SLUG")
+	GithubAppKeyPath = os.Getenv("GITHUB_APP_PRIVATE_KEY_PATH")
+
+	// Setting DATABASE_URL stores data in PostgreSQL instead of SQLite.
+	DatabaseURL  = os.Getenv("DATABASE_URL")
+	DatabasePath = os.Getenv("DATABASE_PATH")
+	// BACKUP_DIR defaults to a backups directory next to the database.
+	BackupDir = os.Getenv("BACKUP_DIR")
 )
 
+var startedAt = time.Now()
+
 func main() {
-	if BotToken == "" || GithubClientID == "" || GithubSecret == "" || BaseURL == "" || WebhookSecret == "" {
+	if len(os.Args) > 1 {
+		if err := runCLI(os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if githubAppEnabled() {
+		if BotToken == "" || GithubAppSlug == "" || GithubAppKeyPath == "" || BaseURL == "" || WebhookSecret == "" {
+			log.Fatal("One or more required environment variables are missing: DISCORD_BOT_TOKEN, GITHUB_APP_SLUG, GITHUB_APP_PRIVATE_KEY_PATH, BASE_URL, WEBHOOK_SECRET")
+		}
+		if err := loadGithubAppKey(GithubAppKeyPath); err != nil {
+			log.Fatalf("Error loading GitHub App private key: %v", err)
+		}
+		log.Printf("Running as GitHub App %s", GithubAppSlug)
+		if GithubClientID == "" || GithubSecret == "" {
+			log.Println("GITHUB_CLIENT_ID and GITHUB_CLIENT_SECRET are not set, so members can only register public repositories.")
+		}
+	} else if BotToken == "" || GithubClientID == "" || GithubSecret == "" || BaseURL == "" || WebhookSecret == "" {
 		log.Fatal("One or more required environment variables are missing: DISCORD_BOT_TOKEN, GITHUB_CLIENT_ID, GITHUB_CLIENT_SECRET, BASE_URL, WEBHOOK_SECRET")
 	}
 
@@ -28,7 +60,9 @@ func main() {
 	}
 	log.Println("Discord session created successfully.")
 
-	db, err := sql.Open("sqlite", "./bot.db")
+	dg.Identify.Intents = discordgo.IntentsGuilds
+
+	db, err := openStore()
 	if err != nil {
 		log.Fatalf("Error opening database: %v", err)
 	}
@@ -38,9 +72,13 @@ func main() {
 			log.Printf("Error closing database: %v", err)
 		}
 	}()
-	log.Println("Database connection established successfully.")
+	log.Printf("Database connection established successfully (%s).", db.dialect.name)
+
+	if err := db.CheckIntegrity(); err != nil {
+		log.Fatalf("Error checking database integrity: %v", err)
+	}
 
-	err = runMigrations(db)
+	err = db.Migrate()
 	if err != nil {
 		log.Fatalf("Error running migrations: %v", err)
 	}
@@ -58,22 +96,25 @@ func main() {
 	}()
 	log.Println("Discord session opened successfully.")
 
+	backfillRegistrationGuilds(db, dg)
+	go backfillGithubLogins(db, dg)
+
 	registerCommands(dg, db)
 	log.Println("Commands registered successfully.")
 
 	appID := dg.State.User.ID
 
-	for _, cmd := range commands {
-		_, err := dg.ApplicationCommandCreate(appID, "", cmd)
-		if err != nil {
-			log.Fatalf("Cannot create '%v' command: %v", cmd.Name, err)
-		}
-	}
+	localizeCommands(commands)
+	syncCommands(dg, appID, DevGuildID)
 
 	http.HandleFunc("/github/callback", func(w http.ResponseWriter, r *http.Request) {
 		handleGithubCallback(db, dg, w, r)
 	})
 
+	http.HandleFunc("/github/setup", func(w http.ResponseWriter, r *http.Request) {
+		handleGithubAppSetup(db, dg, w, r)
+	})
+
 	http.HandleFunc("/webhook", func(w http.ResponseWriter, r *http.Request) {
 		handleWebhook(db, dg, w, r)
 	})
@@ -89,6 +130,12 @@ func main() {
 	go scheduleDailyChecks(db, dg)
 	log.Println("Scheduled daily checks successfully.")
 
+	go scheduleWebhookReconciliation(db)
+	go purgePendingAuths(db)
+	if db.dialect.name == "sqlite" {
+		go scheduleBackups(db)
+	}
+
 	log.Println("Bot is now running.")
 
 	select {}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// MessageData carries every value a message template may reference. User and
+// Buddy are pre-rendered mentions so templates can drop them in directly.
+type MessageData struct {
+	User        string
+	Buddy       string
+	Owner       string
+	Repo        string
+	Message     string
+	Count       int
+	Total       int
+	Name        string
+	Emoji       string
+	Description string
+	Start       string
+	End         string
+	Scoring     string
+}
+
+const defaultTone = "roast"
+
+// Tone preset texts live in the locale catalogs under tone.<tone>.<key>.
+var (
+	messageKeys = []string{
+		"achievement_unlocked",
+		"buddy_accepted",
+		"buddy_declined",
+		"buddy_missed",
+		"buddy_request",
+		"buddy_status",
+		"challenge_created",
+		"challenge_results",
+		"daily_failure",
+		"daily_header",
+		"daily_streak",
+		"daily_success",
+		"new_commit",
+		"repo_registered",
+	}
+	tones = []string{"neutral", "roast", "supportive"}
+)
+
+var sampleMessageData = MessageData{
+	User:        "<@0>",
+	Buddy:       "<@1>",
+	Owner:       "octocat",
+	Repo:        "hello-world",
+	Message:     "Initial commit",
+	Count:       3,
+	Total:       5,
+	Name:        "First Commit",
+	Emoji:       "🌱",
+	Description: "Push your first tracked commit",
+	Start:       "2026-01-01",
+	End:         "2026-01-31",
+	Scoring:     "points",
+}
+
+func presetText(locale discordgo.Locale, tone, key string) string {
+	if !slices.Contains(tones, tone) {
+		tone = defaultTone
+	}
+	text, _ := lookup(locale, "tone."+tone+"."+key)
+	return text
+}
+
+func executeTemplate(text string, data MessageData) (string, error) {
+	tmpl, err := template.New("message").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// validateTemplate parses text and renders it against sample data so that
+// typos in field names are rejected when saved rather than at send time.
+func validateTemplate(key, text string) error {
+	if !slices.Contains(messageKeys, key) {
+		return fmt.Errorf("unknown message key %q", key)
+	}
+	out, err := executeTemplate(text, sampleMessageData)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(out) == "" {
+		return fmt.Errorf("template renders an empty message")
+	}
+	if len(out) > 1800 {
+		return fmt.Errorf("template renders a message longer than 1800 characters")
+	}
+	return nil
+}
+
+func renderMessage(db Store, dg *discordgo.Session, guildID, key string, data MessageData) string {
+	text, tone, err := db.GetMessageTemplate(guildID, key)
+	if err != nil {
+		log.Printf("Error getting message template %s for guild %s: %v", key, guildID, err)
+	}
+	locale := guildLocale(db, dg, guildID)
+	if text == "" {
+		text = presetText(locale, tone, key)
+	}
+
+	out, err := executeTemplate(text, data)
+	if err != nil {
+		log.Printf("Error rendering message template %s for guild %s: %v", key, guildID, err)
+		out, _ = executeTemplate(presetText(locale, defaultTone, key), data)
+	}
+	return out
+}
+
+func handleTemplateCommand(ctx *CommandContext) error {
+	switch ctx.Subcommand {
+	case "set":
+		key := ctx.StringOption("key")
+		text := ctx.StringOption("text")
+		if err := validateTemplate(key, text); err != nil {
+			return ctx.Errorf("template.invalid", err)
+		}
+		if err := ctx.DB.SetMessageTemplate(ctx.GuildID, key, text); err != nil {
+			return ctx.Errorf("template.save_error", err)
+		}
+		preview, _ := executeTemplate(text, sampleMessageData)
+		return ctx.Replyf("template.saved", key, preview)
+
+	case "reset":
+		key := ctx.StringOption("key")
+		if err := ctx.DB.ResetMessageTemplate(ctx.GuildID, key); err != nil {
+			return ctx.Errorf("template.reset_error", err)
+		}
+		return ctx.Replyf("template.reset", key)
+
+	case "tone":
+		tone := ctx.StringOption("preset")
+		if !slices.Contains(tones, tone) {
+			return ctx.Errorf("template.unknown_tone", tone)
+		}
+		if err := ctx.DB.SetGuildTone(ctx.GuildID, tone); err != nil {
+			return ctx.Errorf("template.tone_error", err)
+		}
+		return ctx.Replyf("template.tone_set", tone)
+
+	case "show":
+		// Every preview together is longer than one Discord message, so
+		// they are sent in as many replies as needed.
+		var sb strings.Builder
+		for _, key := range messageKeys {
+			text, tone, err := ctx.DB.GetMessageTemplate(ctx.GuildID, key)
+			if err != nil {
+				return ctx.Errorf("template.load_error", err)
+			}
+			source := tr(ctx.Locale, "template.source_custom")
+			if text == "" {
+				source = tone
+			}
+			entry := tr(ctx.Locale, "template.show_entry", key, source, renderMessage(ctx.DB, ctx.Session, ctx.GuildID, key, sampleMessageData)) + "\n"
+			if sb.Len()+len(entry) > 1900 {
+				if err := ctx.Reply(sb.String()); err != nil {
+					return err
+				}
+				sb.Reset()
+			}
+			sb.WriteString(entry)
+		}
+		return ctx.Reply(sb.String())
+	}
+	return nil
+}
+
+func messageKeyChoices() []*discordgo.ApplicationCommandOptionChoice {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, key := range messageKeys {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: key, Value: key})
+	}
+	return choices
+}
+
+func toneChoices() []*discordgo.ApplicationCommandOptionChoice {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, tone := range tones {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: tone, Value: tone})
+	}
+	return choices
+}
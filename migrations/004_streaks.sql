@@ -0,0 +1,14 @@
+CREATE TABLE streaks (
+    user_id TEXT PRIMARY KEY,
+    current INTEGER NOT NULL DEFAULT 0,
+    longest INTEGER NOT NULL DEFAULT 0,
+    last_active_day TEXT,
+    last_checked_day TEXT
+);
+
+CREATE TABLE streak_roles (
+    guild_id TEXT NOT NULL,
+    threshold INTEGER NOT NULL,
+    role_id TEXT NOT NULL,
+    PRIMARY KEY (guild_id, threshold)
+);
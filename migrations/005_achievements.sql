@@ -0,0 +1,17 @@
+CREATE TABLE commits (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    repo_id INTEGER NOT NULL REFERENCES repos(id) ON DELETE CASCADE,
+    sha TEXT NOT NULL,
+    author TEXT,
+    message TEXT,
+    committed_at TEXT,
+    received_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(repo_id, sha)
+);
+
+CREATE TABLE user_achievements (
+    user_id TEXT NOT NULL,
+    achievement TEXT NOT NULL,
+    unlocked_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, achievement)
+);
@@ -0,0 +1,5 @@
+CREATE TABLE guilds (
+    id TEXT PRIMARY KEY,
+    locale TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,34 @@
+CREATE TABLE streaks_old (
+    user_id TEXT PRIMARY KEY,
+    current INTEGER NOT NULL DEFAULT 0,
+    longest INTEGER NOT NULL DEFAULT 0,
+    last_active_day TEXT,
+    last_checked_day TEXT
+);
+
+INSERT OR IGNORE INTO streaks_old (user_id, current, longest, last_active_day, last_checked_day)
+SELECT user_id, current, longest, last_active_day, last_checked_day FROM streaks ORDER BY longest DESC;
+
+DROP TABLE streaks;
+ALTER TABLE streaks_old RENAME TO streaks;
+
+CREATE TABLE repo_registrations_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id TEXT NOT NULL REFERENCES users(id),
+    repo_id INTEGER NOT NULL REFERENCES repos(id),
+    channel_id TEXT NOT NULL,
+    registered_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, repo_id)
+);
+
+INSERT OR IGNORE INTO repo_registrations_old (id, user_id, repo_id, channel_id, registered_at)
+SELECT id, user_id, repo_id, channel_id, registered_at FROM repo_registrations ORDER BY id;
+
+DROP TABLE repo_registrations;
+ALTER TABLE repo_registrations_old RENAME TO repo_registrations;
+
+ALTER TABLE guilds DROP COLUMN last_check_date;
+ALTER TABLE guilds DROP COLUMN features;
+ALTER TABLE guilds DROP COLUMN check_time;
+ALTER TABLE guilds DROP COLUMN timezone;
+ALTER TABLE guilds DROP COLUMN report_channel_id;
@@ -0,0 +1,37 @@
+ALTER TABLE guilds ADD COLUMN report_channel_id TEXT;
+ALTER TABLE guilds ADD COLUMN timezone TEXT;
+ALTER TABLE guilds ADD COLUMN check_time TEXT NOT NULL DEFAULT '20:00';
+ALTER TABLE guilds ADD COLUMN features TEXT;
+ALTER TABLE guilds ADD COLUMN last_check_date TEXT;
+
+CREATE TABLE repo_registrations_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id TEXT NOT NULL REFERENCES users(id),
+    repo_id INTEGER NOT NULL REFERENCES repos(id),
+    guild_id TEXT NOT NULL DEFAULT '',
+    channel_id TEXT NOT NULL,
+    registered_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, repo_id, guild_id)
+);
+
+INSERT INTO repo_registrations_new (id, user_id, repo_id, channel_id, registered_at)
+SELECT id, user_id, repo_id, channel_id, registered_at FROM repo_registrations;
+
+DROP TABLE repo_registrations;
+ALTER TABLE repo_registrations_new RENAME TO repo_registrations;
+
+CREATE TABLE streaks_new (
+    user_id TEXT NOT NULL,
+    guild_id TEXT NOT NULL DEFAULT '',
+    current INTEGER NOT NULL DEFAULT 0,
+    longest INTEGER NOT NULL DEFAULT 0,
+    last_active_day TEXT,
+    last_checked_day TEXT,
+    PRIMARY KEY (user_id, guild_id)
+);
+
+INSERT INTO streaks_new (user_id, current, longest, last_active_day, last_checked_day)
+SELECT user_id, current, longest, last_active_day, last_checked_day FROM streaks;
+
+DROP TABLE streaks;
+ALTER TABLE streaks_new RENAME TO streaks;
@@ -0,0 +1,2 @@
+DROP TABLE admin_audit_log;
+ALTER TABLE guilds DROP COLUMN admin_role_id;
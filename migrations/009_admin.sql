@@ -0,0 +1,10 @@
+ALTER TABLE guilds ADD COLUMN admin_role_id TEXT;
+
+CREATE TABLE admin_audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    guild_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    action TEXT NOT NULL,
+    details TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
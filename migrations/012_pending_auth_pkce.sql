@@ -0,0 +1,2 @@
+ALTER TABLE pending_auths ADD COLUMN code_verifier TEXT NOT NULL DEFAULT '';
+ALTER TABLE pending_auths ADD COLUMN interaction_token TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE repos DROP COLUMN installation_id;
+DROP TABLE app_installations;
@@ -0,0 +1,2 @@
+DROP INDEX idx_commits_author_login;
+ALTER TABLE commits DROP COLUMN author_login;
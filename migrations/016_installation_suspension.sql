@@ -0,0 +1 @@
+ALTER TABLE app_installations ADD COLUMN suspended_at DATETIME;
@@ -0,0 +1,3 @@
+DROP TABLE repo_registrations;
+DROP TABLE repos;
+DROP TABLE users;
@@ -0,0 +1,24 @@
+CREATE TABLE users (
+    id TEXT PRIMARY KEY,
+    github_token TEXT,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE repos (
+    id BIGSERIAL PRIMARY KEY,
+    owner TEXT NOT NULL,
+    name TEXT NOT NULL,
+    webhook_id BIGINT,
+    webhook_secret TEXT,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(owner, name)
+);
+
+CREATE TABLE repo_registrations (
+    id BIGSERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id),
+    repo_id BIGINT NOT NULL REFERENCES repos(id),
+    channel_id TEXT NOT NULL,
+    registered_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, repo_id)
+);
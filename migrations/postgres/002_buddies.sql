@@ -0,0 +1,8 @@
+CREATE TABLE buddies (
+    id BIGSERIAL PRIMARY KEY,
+    requester_id TEXT NOT NULL,
+    partner_id TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(requester_id, partner_id)
+);
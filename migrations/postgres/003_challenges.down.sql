@@ -0,0 +1,2 @@
+DROP TABLE challenge_participants;
+DROP TABLE challenges;
@@ -0,0 +1,24 @@
+CREATE TABLE challenges (
+    id BIGSERIAL PRIMARY KEY,
+    guild_id TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    creator_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    min_repos INTEGER NOT NULL DEFAULT 1,
+    scoring TEXT NOT NULL DEFAULT 'elimination',
+    start_date TEXT NOT NULL,
+    end_date TEXT NOT NULL,
+    last_evaluated TEXT,
+    finished INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(guild_id, name)
+);
+
+CREATE TABLE challenge_participants (
+    challenge_id BIGINT NOT NULL REFERENCES challenges(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL,
+    points INTEGER NOT NULL DEFAULT 0,
+    eliminated INTEGER NOT NULL DEFAULT 0,
+    joined_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (challenge_id, user_id)
+);
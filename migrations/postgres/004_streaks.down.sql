@@ -0,0 +1,2 @@
+DROP TABLE streak_roles;
+DROP TABLE streaks;
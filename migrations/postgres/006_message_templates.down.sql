@@ -0,0 +1,2 @@
+DROP TABLE guild_tones;
+DROP TABLE message_templates;
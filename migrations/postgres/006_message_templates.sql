@@ -0,0 +1,12 @@
+CREATE TABLE message_templates (
+    guild_id TEXT NOT NULL,
+    key TEXT NOT NULL,
+    template TEXT NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (guild_id, key)
+);
+
+CREATE TABLE guild_tones (
+    guild_id TEXT PRIMARY KEY,
+    tone TEXT NOT NULL
+);
@@ -0,0 +1,17 @@
+ALTER TABLE streaks DROP CONSTRAINT streaks_pkey;
+DELETE FROM streaks a USING streaks b
+WHERE a.user_id = b.user_id AND (a.longest, a.guild_id) < (b.longest, b.guild_id);
+ALTER TABLE streaks DROP COLUMN guild_id;
+ALTER TABLE streaks ADD PRIMARY KEY (user_id);
+
+ALTER TABLE repo_registrations DROP CONSTRAINT repo_registrations_user_id_repo_id_guild_id_key;
+DELETE FROM repo_registrations a USING repo_registrations b
+WHERE a.user_id = b.user_id AND a.repo_id = b.repo_id AND a.id > b.id;
+ALTER TABLE repo_registrations DROP COLUMN guild_id;
+ALTER TABLE repo_registrations ADD UNIQUE (user_id, repo_id);
+
+ALTER TABLE guilds DROP COLUMN last_check_date;
+ALTER TABLE guilds DROP COLUMN features;
+ALTER TABLE guilds DROP COLUMN check_time;
+ALTER TABLE guilds DROP COLUMN timezone;
+ALTER TABLE guilds DROP COLUMN report_channel_id;
@@ -0,0 +1,13 @@
+ALTER TABLE guilds ADD COLUMN report_channel_id TEXT;
+ALTER TABLE guilds ADD COLUMN timezone TEXT;
+ALTER TABLE guilds ADD COLUMN check_time TEXT NOT NULL DEFAULT '20:00';
+ALTER TABLE guilds ADD COLUMN features TEXT;
+ALTER TABLE guilds ADD COLUMN last_check_date TEXT;
+
+ALTER TABLE repo_registrations ADD COLUMN guild_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE repo_registrations DROP CONSTRAINT repo_registrations_user_id_repo_id_key;
+ALTER TABLE repo_registrations ADD UNIQUE (user_id, repo_id, guild_id);
+
+ALTER TABLE streaks ADD COLUMN guild_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE streaks DROP CONSTRAINT streaks_pkey;
+ALTER TABLE streaks ADD PRIMARY KEY (user_id, guild_id);
@@ -0,0 +1 @@
+DROP TABLE orphaned_webhooks;
@@ -0,0 +1,9 @@
+CREATE TABLE orphaned_webhooks (
+    id BIGSERIAL PRIMARY KEY,
+    owner TEXT NOT NULL,
+    name TEXT NOT NULL,
+    webhook_id BIGINT NOT NULL,
+    user_id TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(owner, name, webhook_id)
+);
@@ -0,0 +1,13 @@
+CREATE TABLE pending_auths (
+    state TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    guild_id TEXT NOT NULL DEFAULT '',
+    owner TEXT NOT NULL,
+    name TEXT NOT NULL,
+    channel_id TEXT NOT NULL,
+    locale TEXT NOT NULL DEFAULT '',
+    expires_at BIGINT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_pending_auths_expires_at ON pending_auths(expires_at);
@@ -0,0 +1,2 @@
+ALTER TABLE pending_auths DROP COLUMN interaction_token;
+ALTER TABLE pending_auths DROP COLUMN code_verifier;
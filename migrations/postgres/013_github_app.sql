@@ -0,0 +1,7 @@
+CREATE TABLE app_installations (
+    id BIGINT PRIMARY KEY,
+    account_login TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE repos ADD COLUMN installation_id BIGINT;
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN token_revoked_at;
+ALTER TABLE users DROP COLUMN github_login;
@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN github_login TEXT;
+ALTER TABLE users ADD COLUMN token_revoked_at TIMESTAMPTZ;
@@ -0,0 +1,2 @@
+ALTER TABLE commits ADD COLUMN author_login TEXT;
+CREATE INDEX idx_commits_author_login ON commits (author_login);
@@ -0,0 +1 @@
+ALTER TABLE app_installations DROP COLUMN suspended_at;
@@ -2,18 +2,30 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
 )
 
-func registerRepo(db *sql.DB, userID, owner, repo, channeltID string) error {
-	tx, err := db.Begin()
+func (s *sqlStore) RegisterRepo(userID, owner, repo, guildID, channeltID string) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.exec(`INSERT INTO users (id) VALUES (?) ON CONFLICT DO NOTHING`, userID)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	_, err = tx.Exec(`
-			INSERT OR IGNORE INTO repos (owner, name) 
-			VALUES (?, ?)`,
+	_, err = tx.exec(`
+			INSERT INTO repos (owner, name)
+			VALUES (?, ?)
+			ON CONFLICT DO NOTHING`,
 		owner, repo)
 	if err != nil {
 		tx.Rollback()
@@ -21,16 +33,16 @@ func registerRepo(db *sql.DB, userID, owner, repo, channeltID string) error {
 	}
 
 	var repoID int
-	err = tx.QueryRow(`SELECT id FROM repos WHERE owner = ? AND name = ?`, owner, repo).Scan(&repoID)
+	err = tx.queryRow(`SELECT id FROM repos WHERE owner = ? AND name = ?`, owner, repo).Scan(&repoID)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	_, err = tx.Exec(`
-		INSERT INTO repo_registrations (user_id, repo_id, channel_id)
-		VALUES (?, ?, ?)`,
-		userID, repoID, channeltID)
+	_, err = tx.exec(`
+		INSERT INTO repo_registrations (user_id, repo_id, guild_id, channel_id)
+		VALUES (?, ?, ?, ?)`,
+		userID, repoID, guildID, channeltID)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -39,12 +51,14 @@ func registerRepo(db *sql.DB, userID, owner, repo, channeltID string) error {
 	return tx.Commit()
 }
 
-func getAllRegisteredUserIDs(db *sql.DB) ([]struct{ UserID, ChannelID, Owner, Repo string }, error) {
-	rows, err := db.Query(`
-		SELECT rr.user_id, rr.channel_id, r.owner, r.name
-		FROM repo_registrations rr
-		JOIN repos r ON rr.repo_id = r.id
-		`)
+// GetGuildRegisteredUsers returns each user with registrations in the guild
+// once, along with the channel they registered from.
+func (s *sqlStore) GetGuildRegisteredUsers(guildID string) ([]struct{ UserID, ChannelID string }, error) {
+	rows, err := s.query(`
+		SELECT user_id, MIN(channel_id)
+		FROM repo_registrations
+		WHERE guild_id = ?
+		GROUP BY user_id`, guildID)
 	if err != nil {
 		return nil, err
 	}
@@ -55,10 +69,10 @@ func getAllRegisteredUserIDs(db *sql.DB) ([]struct{ UserID, ChannelID, Owner, Re
 		}
 	}()
 
-	var users []struct{ UserID, ChannelID, Owner, Repo string }
+	var users []struct{ UserID, ChannelID string }
 	for rows.Next() {
-		var user struct{ UserID, ChannelID, Owner, Repo string }
-		if err := rows.Scan(&user.UserID, &user.ChannelID, &user.Owner, &user.Repo); err != nil {
+		var user struct{ UserID, ChannelID string }
+		if err := rows.Scan(&user.UserID, &user.ChannelID); err != nil {
 			log.Printf("Error scanning row: %v", err)
 			continue
 		}
@@ -67,12 +81,77 @@ func getAllRegisteredUserIDs(db *sql.DB) ([]struct{ UserID, ChannelID, Owner, Re
 	return users, nil
 }
 
-func getReposByUserID(db *sql.DB, userID string) ([]struct{ Owner, Name, ChannelID string }, error) {
-	rows, err := db.Query(`
-		SELECT r.owner, r.name, rr.channel_id
+func (s *sqlStore) GetActiveGuildIDs() ([]string, error) {
+	rows, err := s.query(`
+		SELECT id FROM guilds
+		UNION
+		SELECT DISTINCT guild_id FROM repo_registrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var results []string
+	for rows.Next() {
+		var guildID string
+		if err := rows.Scan(&guildID); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		results = append(results, guildID)
+	}
+	return results, nil
+}
+
+func (s *sqlStore) GetRegistrationsWithoutGuild() ([]struct {
+	ID        int64
+	ChannelID string
+}, error) {
+	rows, err := s.query(`SELECT id, channel_id FROM repo_registrations WHERE guild_id = ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var results []struct {
+		ID        int64
+		ChannelID string
+	}
+	for rows.Next() {
+		var r struct {
+			ID        int64
+			ChannelID string
+		}
+		if err := rows.Scan(&r.ID, &r.ChannelID); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func (s *sqlStore) SetRegistrationGuild(registrationID int64, guildID string) error {
+	_, err := s.exec(`UPDATE repo_registrations SET guild_id = ? WHERE id = ?`, guildID, registrationID)
+	return err
+}
+
+// GetReposByUserID lists the user's registered repos in guildID, or across
+// every guild when guildID is empty.
+func (s *sqlStore) GetReposByUserID(userID, guildID string) ([]struct{ Owner, Name, ChannelID string }, error) {
+	rows, err := s.query(`
+		SELECT DISTINCT r.owner, r.name, rr.channel_id
 		FROM repos r
 		JOIN repo_registrations rr ON r.id = rr.repo_id
-		WHERE rr.user_id = ?`, userID)
+		WHERE rr.user_id = ? AND (? = '' OR rr.guild_id = ?)`, userID, guildID, guildID)
 	if err != nil {
 		return nil, err
 	}
@@ -95,9 +174,9 @@ func getReposByUserID(db *sql.DB, userID string) ([]struct{ Owner, Name, Channel
 	return results, nil
 }
 
-func getUserIDsByRepo(db *sql.DB, owner, repo string) ([]struct{ UserID, ChannelID string }, error) {
-	rows, err := db.Query(`
-		SELECT DISTINCT rr.user_id, rr.channel_id
+func (s *sqlStore) GetUserIDsByRepo(owner, repo string) ([]struct{ UserID, GuildID, ChannelID string }, error) {
+	rows, err := s.query(`
+		SELECT DISTINCT rr.user_id, rr.guild_id, rr.channel_id
 		FROM repos r
 		JOIN repo_registrations rr ON r.id = rr.repo_id
 		WHERE r.owner = ? AND r.name = ?`, owner, repo)
@@ -110,10 +189,10 @@ func getUserIDsByRepo(db *sql.DB, owner, repo string) ([]struct{ UserID, Channel
 		}
 	}()
 
-	var results []struct{ UserID, ChannelID string }
+	var results []struct{ UserID, GuildID, ChannelID string }
 	for rows.Next() {
-		var user struct{ UserID, ChannelID string }
-		if err := rows.Scan(&user.UserID, &user.ChannelID); err != nil {
+		var user struct{ UserID, GuildID, ChannelID string }
+		if err := rows.Scan(&user.UserID, &user.GuildID, &user.ChannelID); err != nil {
 			log.Printf("Error scanning row: %v", err)
 			continue
 		}
@@ -122,56 +201,1309 @@ func getUserIDsByRepo(db *sql.DB, owner, repo string) ([]struct{ UserID, Channel
 	return results, nil
 }
 
-func storesGithubToken(db *sql.DB, userID, accessToken string) error {
-	_, err := db.Exec(`
-		INSERT INTO users (id, github_token)
-		VALUES (?, ?)
-		ON CONFLICT(id) DO UPDATE SET github_token = excluded.github_token`,
-		userID, accessToken)
+func (s *sqlStore) StoreGithubToken(userID, accessToken, login string) error {
+	_, err := s.exec(`
+		INSERT INTO users (id, github_token, github_login)
+		VALUES (?, ?, NULLIF(?, ''))
+		ON CONFLICT(id) DO UPDATE SET
+			github_token = excluded.github_token,
+			github_login = COALESCE(excluded.github_login, users.github_login),
+			token_revoked_at = NULL`,
+		userID, accessToken, login)
 	return err
 }
 
-func getGithubToken(db *sql.DB, userID string) (string, error) {
+// GetTokensWithoutGithubLogin lists users who authorized before their
+// GitHub login was recorded.
+func (s *sqlStore) GetTokensWithoutGithubLogin() ([]struct{ UserID, Token string }, error) {
+	rows, err := s.query(`SELECT id, github_token FROM users WHERE github_token IS NOT NULL AND github_login IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var results []struct{ UserID, Token string }
+	for rows.Next() {
+		var r struct{ UserID, Token string }
+		if err := rows.Scan(&r.UserID, &r.Token); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// MarkGithubTokenRevoked drops the user's token and reports whether there
+// was one to drop, so callers only react to a revocation once.
+func (s *sqlStore) MarkGithubTokenRevoked(userID string) (bool, error) {
+	res, err := s.exec(`
+		UPDATE users SET github_token = NULL, token_revoked_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND github_token IS NOT NULL`, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *sqlStore) GetUserIDsByGithubLogin(login string) ([]string, error) {
+	rows, err := s.query(`SELECT id FROM users WHERE LOWER(github_login) = LOWER(?)`, login)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// GetUserGuildIDs lists the guilds the user has registrations in.
+func (s *sqlStore) GetUserGuildIDs(userID string) ([]string, error) {
+	rows, err := s.query(`
+		SELECT DISTINCT guild_id FROM repo_registrations
+		WHERE user_id = ? AND guild_id != ''`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var guildIDs []string
+	for rows.Next() {
+		var guildID string
+		if err := rows.Scan(&guildID); err != nil {
+			return nil, err
+		}
+		guildIDs = append(guildIDs, guildID)
+	}
+	return guildIDs, rows.Err()
+}
+
+func (s *sqlStore) GetGithubToken(userID string) (string, error) {
 	var token string
-	err := db.QueryRow(`SELECT github_token FROM users WHERE id = ?`, userID).Scan(&token)
+	err := s.queryRow(`SELECT COALESCE(github_token, '') FROM users WHERE id = ?`, userID).Scan(&token)
 	return token, err
 }
 
-func storeWebhookID(db *sql.DB, owner, repo string, webhookID int64, secret string) error {
-	_, err := db.Exec(`
+func (s *sqlStore) CreatePendingAuth(state string, pending PendingAuth) error {
+	_, err := s.exec(`
+		INSERT INTO pending_auths (state, user_id, guild_id, owner, name, channel_id, locale, code_verifier, interaction_token, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		state, pending.DiscordUserID, pending.GuildID, pending.Owner, pending.Repo, pending.ChannelID, string(pending.Locale),
+		pending.CodeVerifier, pending.InteractionToken, pending.ExpiresAt.Unix())
+	return err
+}
+
+// ConsumePendingAuth deletes and returns the state in one statement, so a
+// state can only ever be used once even if the callback is hit twice.
+// Expired or unknown states return sql.ErrNoRows.
+func (s *sqlStore) ConsumePendingAuth(state string, now time.Time) (PendingAuth, error) {
+	var pending PendingAuth
+	var locale string
+	var expiresAt int64
+	err := s.queryRow(`
+		DELETE FROM pending_auths WHERE state = ? AND expires_at > ?
+		RETURNING user_id, guild_id, owner, name, channel_id, locale, code_verifier, interaction_token, expires_at`,
+		state, now.Unix()).
+		Scan(&pending.DiscordUserID, &pending.GuildID, &pending.Owner, &pending.Repo, &pending.ChannelID, &locale,
+			&pending.CodeVerifier, &pending.InteractionToken, &expiresAt)
+	pending.Locale = discordgo.Locale(locale)
+	pending.ExpiresAt = time.Unix(expiresAt, 0)
+	return pending, err
+}
+
+func (s *sqlStore) PurgeExpiredPendingAuths(now time.Time) (int64, error) {
+	res, err := s.exec(`DELETE FROM pending_auths WHERE expires_at <= ?`, now.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetRepoWebhookID returns the webhook tracking the repo, or 0 when the repo
+// is unknown or has none.
+func (s *sqlStore) GetRepoWebhookID(owner, repo string) (int64, error) {
+	var webhookID int64
+	err := s.queryRow(`
+		SELECT COALESCE(webhook_id, 0) FROM repos
+		WHERE LOWER(owner) = LOWER(?) AND LOWER(name) = LOWER(?)`,
+		owner, repo).Scan(&webhookID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return webhookID, err
+}
+
+func (s *sqlStore) UpsertInstallation(installationID int64, account string) error {
+	_, err := s.exec(`
+		INSERT INTO app_installations (id, account_login) VALUES (?, ?)
+		ON CONFLICT(id) DO UPDATE SET account_login = COALESCE(NULLIF(excluded.account_login, ''), app_installations.account_login)`,
+		installationID, account)
+	return err
+}
+
+func (s *sqlStore) DeleteInstallation(installationID int64) error {
+	if err := s.ClearInstallationRepos(installationID); err != nil {
+		return err
+	}
+	_, err := s.exec(`DELETE FROM app_installations WHERE id = ?`, installationID)
+	return err
+}
+
+// SetInstallationSuspended marks an installation suspended or active again.
+// A suspended installation keeps its repos but covers none of them until it
+// is unsuspended.
+func (s *sqlStore) SetInstallationSuspended(installationID int64, suspended bool) error {
+	query := `UPDATE app_installations SET suspended_at = NULL WHERE id = ?`
+	if suspended {
+		query = `UPDATE app_installations SET suspended_at = CURRENT_TIMESTAMP WHERE id = ?`
+	}
+	_, err := s.exec(query, installationID)
+	return err
+}
+
+func (s *sqlStore) ClearInstallationRepos(installationID int64) error {
+	_, err := s.exec(`UPDATE repos SET installation_id = NULL WHERE installation_id = ?`, installationID)
+	return err
+}
+
+// SetRepoInstallation records which installation covers the repo, creating
+// the repo if needed. An installationID of 0 clears it.
+func (s *sqlStore) SetRepoInstallation(owner, repo string, installationID int64) error {
+	_, err := s.exec(`
+		INSERT INTO repos (owner, name, installation_id) VALUES (?, ?, NULLIF(?, 0))
+		ON CONFLICT(owner, name) DO UPDATE SET installation_id = excluded.installation_id`,
+		owner, repo, installationID)
+	return err
+}
+
+// GetRepoInstallation returns the installation covering the repo along with
+// its canonical "owner/name", or 0 when the app is not installed on it or
+// the installation is suspended.
+func (s *sqlStore) GetRepoInstallation(owner, repo string) (int64, string, error) {
+	var installationID int64
+	var fullName string
+	err := s.queryRow(`
+		SELECT installation_id, owner || '/' || name FROM repos
+		WHERE LOWER(owner) = LOWER(?) AND LOWER(name) = LOWER(?) AND installation_id IS NOT NULL
+			AND installation_id NOT IN (SELECT id FROM app_installations WHERE suspended_at IS NOT NULL)`,
+		owner, repo).Scan(&installationID, &fullName)
+	if err == sql.ErrNoRows {
+		return 0, "", nil
+	}
+	return installationID, fullName, err
+}
+
+type TrackedRepo struct {
+	Owner          string
+	Name           string
+	WebhookID      int64
+	InstallationID int64
+	// Token belongs to one of the repo's registrants, or is empty when none
+	// of them has a stored token.
+	Token string
+}
+
+func (s *sqlStore) GetTrackedRepos() ([]TrackedRepo, error) {
+	rows, err := s.query(`
+		SELECT r.owner, r.name, COALESCE(r.webhook_id, 0), CASE
+			WHEN r.installation_id IN (SELECT id FROM app_installations WHERE suspended_at IS NOT NULL) THEN 0
+			ELSE COALESCE(r.installation_id, 0)
+		END, COALESCE((
+			SELECT u.github_token FROM repo_registrations rr
+			JOIN users u ON u.id = rr.user_id
+			WHERE rr.repo_id = r.id AND COALESCE(u.github_token, '') != ''
+			LIMIT 1
+		), '')
+		FROM repos r
+		WHERE EXISTS (SELECT 1 FROM repo_registrations rr WHERE rr.repo_id = r.id)`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var repos []TrackedRepo
+	for rows.Next() {
+		var r TrackedRepo
+		if err := rows.Scan(&r.Owner, &r.Name, &r.WebhookID, &r.InstallationID, &r.Token); err != nil {
+			return nil, err
+		}
+		repos = append(repos, r)
+	}
+	return repos, rows.Err()
+}
+
+type OrphanedWebhook struct {
+	ID        int64
+	Owner     string
+	Name      string
+	WebhookID int64
+	UserID    string
+}
+
+// AddOrphanedWebhook remembers a hook whose delete failed so that
+// reconciliation can retry it with the token of the user who removed it.
+func (s *sqlStore) AddOrphanedWebhook(owner, repo string, webhookID int64, userID string) error {
+	_, err := s.exec(`
+		INSERT INTO orphaned_webhooks (owner, name, webhook_id, user_id)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`,
+		owner, repo, webhookID, userID)
+	return err
+}
+
+func (s *sqlStore) GetOrphanedWebhooks() ([]OrphanedWebhook, error) {
+	rows, err := s.query(`SELECT id, owner, name, webhook_id, user_id FROM orphaned_webhooks`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var orphans []OrphanedWebhook
+	for rows.Next() {
+		var o OrphanedWebhook
+		if err := rows.Scan(&o.ID, &o.Owner, &o.Name, &o.WebhookID, &o.UserID); err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, o)
+	}
+	return orphans, rows.Err()
+}
+
+func (s *sqlStore) RemoveOrphanedWebhook(id int64) error {
+	_, err := s.exec(`DELETE FROM orphaned_webhooks WHERE id = ?`, id)
+	return err
+}
+
+func (s *sqlStore) IsRepoRegistered(userID, owner, repo, guildID string) (bool, error) {
+	var exists bool
+	err := s.queryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM repo_registrations rr
+			JOIN repos r ON r.id = rr.repo_id
+			WHERE rr.user_id = ? AND rr.guild_id = ?
+			AND LOWER(r.owner) = LOWER(?) AND LOWER(r.name) = LOWER(?)
+		)`, userID, guildID, owner, repo).Scan(&exists)
+	return exists, err
+}
+
+func (s *sqlStore) StoreWebhookID(owner, repo string, webhookID int64, secret string) error {
+	_, err := s.exec(`
 		UPDATE repos SET webhook_id = ?, webhook_secret = ?
 		WHERE owner = ? AND name = ?`,
 		webhookID, secret, owner, repo)
 	return err
 }
 
-func unregisterRepo(db *sql.DB, userID, owner, repo string) (webhookID int64, shouldDelete bool, err error) {
-	tx, err := db.Begin()
+func (s *sqlStore) UnregisterRepo(userID, owner, repo, guildID string) (webhookID int64, shouldDelete bool, err error) {
+	tx, err := s.begin()
 	if err != nil {
 		return 0, false, err
 	}
 
 	var repoID int
-	err = tx.QueryRow(`SELECT id FROM repos WHERE owner = ? AND name = ?`, owner, repo).Scan(&repoID)
+	err = tx.queryRow(`SELECT id FROM repos WHERE owner = ? AND name = ?`, owner, repo).Scan(&repoID)
 	if err != nil {
 		tx.Rollback()
 		return 0, false, err
 	}
 
-	_, err = tx.Exec(`DELETE FROM repo_registrations WHERE user_id = ? AND repo_id = ?`, userID, repoID)
+	res, err := tx.exec(`DELETE FROM repo_registrations WHERE user_id = ? AND repo_id = ? AND guild_id = ?`, userID, repoID, guildID)
 	if err != nil {
 		tx.Rollback()
 		return 0, false, err
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		return 0, false, sql.ErrNoRows
+	}
 
 	var remaining int
-	tx.QueryRow(`SELECT COUNT(*) FROM repo_registrations WHERE repo_id = ?`, repoID).Scan(&remaining)
+	tx.queryRow(`SELECT COUNT(*) FROM repo_registrations WHERE repo_id = ?`, repoID).Scan(&remaining)
 
 	if remaining == 0 {
-		tx.QueryRow("SELECT COALESCE(webhook_id, 0) FROM repos WHERE id = ?", repoID).Scan(&webhookID)
-		tx.Exec(`DELETE FROM repos WHERE id = ?`, repoID)
+		tx.queryRow("SELECT COALESCE(webhook_id, 0) FROM repos WHERE id = ?", repoID).Scan(&webhookID)
+		// Repos covered by a GitHub App installation are kept so the
+		// installation is remembered for the next registration.
+		tx.exec(`DELETE FROM repos WHERE id = ? AND installation_id IS NULL`, repoID)
+		tx.exec(`UPDATE repos SET webhook_id = NULL WHERE id = ?`, repoID)
 		shouldDelete = true
 	}
 
 	return webhookID, shouldDelete, tx.Commit()
 }
+
+func (s *sqlStore) RequestBuddy(requesterID, partnerID string) error {
+	var existing int
+	err := s.queryRow(`
+		SELECT COUNT(*) FROM buddies
+		WHERE status = 'accepted' AND (requester_id IN (?, ?) OR partner_id IN (?, ?))`,
+		requesterID, partnerID, requesterID, partnerID).Scan(&existing)
+	if err != nil {
+		return err
+	}
+	if existing > 0 {
+		return fmt.Errorf("one of you already has an accountability buddy")
+	}
+
+	_, err = s.exec(`
+		INSERT INTO buddies (requester_id, partner_id)
+		VALUES (?, ?)
+		ON CONFLICT(requester_id, partner_id) DO UPDATE SET status = 'pending'`,
+		requesterID, partnerID)
+	return err
+}
+
+func (s *sqlStore) RespondToBuddyRequest(requesterID, partnerID string, accept bool) error {
+	if !accept {
+		res, err := s.exec(`
+			DELETE FROM buddies
+			WHERE requester_id = ? AND partner_id = ? AND status = 'pending'`,
+			requesterID, partnerID)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("no pending buddy request found")
+		}
+		return nil
+	}
+
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+
+	// Touching every row of both users locks them on PostgreSQL, so two
+	// requests accepted at once can't both pass the check below. SQLite
+	// transactions already hold the write lock.
+	_, err = tx.exec(`
+		UPDATE buddies SET status = status
+		WHERE requester_id IN (?1, ?2) OR partner_id IN (?1, ?2)`,
+		requesterID, partnerID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var existing int
+	err = tx.queryRow(`
+		SELECT COUNT(*) FROM buddies
+		WHERE status = 'accepted' AND (requester_id IN (?1, ?2) OR partner_id IN (?1, ?2))`,
+		requesterID, partnerID).Scan(&existing)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if existing > 0 {
+		tx.Rollback()
+		return fmt.Errorf("one of you already has an accountability buddy")
+	}
+
+	res, err := tx.exec(`
+		UPDATE buddies SET status = 'accepted'
+		WHERE requester_id = ? AND partner_id = ? AND status = 'pending'`,
+		requesterID, partnerID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n == 0 {
+		tx.Rollback()
+		return fmt.Errorf("no pending buddy request found")
+	}
+
+	// Each user has one buddy, so their other requests can't be accepted
+	// anymore.
+	_, err = tx.exec(`
+		DELETE FROM buddies
+		WHERE status = 'pending' AND (requester_id IN (?1, ?2) OR partner_id IN (?1, ?2))`,
+		requesterID, partnerID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStore) GetBuddyID(userID string) (string, error) {
+	var buddyID string
+	err := s.queryRow(`
+		SELECT CASE WHEN requester_id = ? THEN partner_id ELSE requester_id END
+		FROM buddies
+		WHERE status = 'accepted' AND (requester_id = ? OR partner_id = ?)`,
+		userID, userID, userID).Scan(&buddyID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return buddyID, err
+}
+
+func (s *sqlStore) RemoveBuddy(userID string) (string, error) {
+	buddyID, err := s.GetBuddyID(userID)
+	if err != nil || buddyID == "" {
+		return "", err
+	}
+
+	_, err = s.exec(`
+		DELETE FROM buddies
+		WHERE (requester_id = ? AND partner_id = ?) OR (requester_id = ? AND partner_id = ?)`,
+		userID, buddyID, buddyID, userID)
+	return buddyID, err
+}
+
+type Challenge struct {
+	ID        int64
+	GuildID   string
+	ChannelID string
+	CreatorID string
+	Name      string
+	MinRepos  int
+	Scoring   string
+	StartDate string
+	EndDate   string
+	LastEval  string
+	Finished  bool
+}
+
+type ChallengeParticipant struct {
+	UserID     string
+	Points     int
+	Eliminated bool
+}
+
+func (s *sqlStore) CreateChallenge(c Challenge) error {
+	_, err := s.exec(`
+		INSERT INTO challenges (guild_id, channel_id, creator_id, name, min_repos, scoring, start_date, end_date)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.GuildID, c.ChannelID, c.CreatorID, c.Name, c.MinRepos, c.Scoring, c.StartDate, c.EndDate)
+	return err
+}
+
+func (s *sqlStore) GetChallenge(guildID, name string) (Challenge, error) {
+	var c Challenge
+	err := s.queryRow(`
+		SELECT id, guild_id, channel_id, creator_id, name, min_repos, scoring, start_date, end_date, COALESCE(last_evaluated, ''), finished
+		FROM challenges WHERE guild_id = ? AND name = ?`, guildID, name).
+		Scan(&c.ID, &c.GuildID, &c.ChannelID, &c.CreatorID, &c.Name, &c.MinRepos, &c.Scoring, &c.StartDate, &c.EndDate, &c.LastEval, &c.Finished)
+	return c, err
+}
+
+func (s *sqlStore) GetUnfinishedChallenges(guildID string) ([]Challenge, error) {
+	rows, err := s.query(`
+		SELECT id, guild_id, channel_id, creator_id, name, min_repos, scoring, start_date, end_date, COALESCE(last_evaluated, ''), finished
+		FROM challenges WHERE finished = 0 AND guild_id = ?`, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var results []Challenge
+	for rows.Next() {
+		var c Challenge
+		if err := rows.Scan(&c.ID, &c.GuildID, &c.ChannelID, &c.CreatorID, &c.Name, &c.MinRepos, &c.Scoring, &c.StartDate, &c.EndDate, &c.LastEval, &c.Finished); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		results = append(results, c)
+	}
+	return results, nil
+}
+
+func (s *sqlStore) MarkChallengeEvaluated(challengeID int64, day string) error {
+	_, err := s.exec(`UPDATE challenges SET last_evaluated = ? WHERE id = ?`, day, challengeID)
+	return err
+}
+
+func (s *sqlStore) FinishChallenge(challengeID int64) error {
+	_, err := s.exec(`UPDATE challenges SET finished = 1 WHERE id = ?`, challengeID)
+	return err
+}
+
+func (s *sqlStore) JoinChallenge(challengeID int64, userID string) error {
+	_, err := s.exec(`
+		INSERT INTO challenge_participants (challenge_id, user_id)
+		VALUES (?, ?)`,
+		challengeID, userID)
+	return err
+}
+
+func (s *sqlStore) LeaveChallenge(challengeID int64, userID string) (bool, error) {
+	res, err := s.exec(`DELETE FROM challenge_participants WHERE challenge_id = ? AND user_id = ?`, challengeID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *sqlStore) GetChallengeParticipants(challengeID int64) ([]ChallengeParticipant, error) {
+	rows, err := s.query(`
+		SELECT user_id, points, eliminated
+		FROM challenge_participants
+		WHERE challenge_id = ?
+		ORDER BY eliminated ASC, points DESC, joined_at ASC`, challengeID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var results []ChallengeParticipant
+	for rows.Next() {
+		var p ChallengeParticipant
+		if err := rows.Scan(&p.UserID, &p.Points, &p.Eliminated); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		results = append(results, p)
+	}
+	return results, nil
+}
+
+func (s *sqlStore) RecordChallengeDay(challengeID int64, userID string, passed, eliminate bool) error {
+	var err error
+	if passed {
+		_, err = s.exec(`
+			UPDATE challenge_participants SET points = points + 1
+			WHERE challenge_id = ? AND user_id = ?`, challengeID, userID)
+	} else if eliminate {
+		_, err = s.exec(`
+			UPDATE challenge_participants SET eliminated = 1
+			WHERE challenge_id = ? AND user_id = ?`, challengeID, userID)
+	}
+	return err
+}
+
+func (s *sqlStore) UpdateStreak(userID, guildID string, day time.Time, active bool) (int, error) {
+	today := day.Format(time.DateOnly)
+	yesterday := day.AddDate(0, 0, -1).Format(time.DateOnly)
+
+	tx, err := s.begin()
+	if err != nil {
+		return 0, err
+	}
+
+	var current, longest int
+	var lastActive, lastChecked string
+	err = tx.queryRow(`
+		SELECT current, longest, COALESCE(last_active_day, ''), COALESCE(last_checked_day, '')
+		FROM streaks WHERE user_id = ? AND guild_id = ?`, userID, guildID).Scan(&current, &longest, &lastActive, &lastChecked)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if lastChecked == today && lastActive == today {
+		tx.Rollback()
+		return current, nil
+	}
+
+	switch {
+	case active && lastActive == today:
+	case active && lastActive == yesterday:
+		current++
+		lastActive = today
+	case active:
+		current = 1
+		lastActive = today
+	default:
+		current = 0
+	}
+	longest = max(longest, current)
+
+	_, err = tx.exec(`
+		INSERT INTO streaks (user_id, guild_id, current, longest, last_active_day, last_checked_day)
+		VALUES (?, ?, ?, ?, NULLIF(?, ''), ?)
+		ON CONFLICT(user_id, guild_id) DO UPDATE SET
+			current = excluded.current,
+			longest = excluded.longest,
+			last_active_day = excluded.last_active_day,
+			last_checked_day = excluded.last_checked_day`,
+		userID, guildID, current, longest, lastActive, today)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return current, tx.Commit()
+}
+
+func (s *sqlStore) GetStreak(userID, guildID string) (int, error) {
+	var current int
+	err := s.queryRow(`SELECT current FROM streaks WHERE user_id = ? AND guild_id = ?`, userID, guildID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return current, err
+}
+
+func (s *sqlStore) SetStreakRole(guildID string, threshold int, roleID string) error {
+	_, err := s.exec(`
+		INSERT INTO streak_roles (guild_id, threshold, role_id)
+		VALUES (?, ?, ?)
+		ON CONFLICT(guild_id, threshold) DO UPDATE SET role_id = excluded.role_id`,
+		guildID, threshold, roleID)
+	return err
+}
+
+func (s *sqlStore) RemoveStreakRole(guildID string, threshold int) (bool, error) {
+	res, err := s.exec(`DELETE FROM streak_roles WHERE guild_id = ? AND threshold = ?`, guildID, threshold)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *sqlStore) GetStreakRoles(guildID string) ([]struct {
+	Threshold int
+	RoleID    string
+}, error) {
+	rows, err := s.query(`
+		SELECT threshold, role_id FROM streak_roles
+		WHERE guild_id = ?
+		ORDER BY threshold ASC`, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var results []struct {
+		Threshold int
+		RoleID    string
+	}
+	for rows.Next() {
+		var r struct {
+			Threshold int
+			RoleID    string
+		}
+		if err := rows.Scan(&r.Threshold, &r.RoleID); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func (s *sqlStore) StoreCommits(owner, repo string, commits []PushCommit) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+
+	var repoID int
+	err = tx.queryRow(`SELECT id FROM repos WHERE owner = ? AND name = ?`, owner, repo).Scan(&repoID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, c := range commits {
+		_, err = tx.exec(`
+			INSERT INTO commits (repo_id, sha, author, author_login, message, committed_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT DO NOTHING`,
+			repoID, c.ID, c.Author.Name, strings.ToLower(c.Author.Username), c.Message, c.Timestamp)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetUserCommitStats counts the commits the user authored in their
+// registered repos, matched by their linked GitHub login.
+func (s *sqlStore) GetUserCommitStats(userID string) (totalCommits, trackedRepos int, err error) {
+	err = s.queryRow(`
+		SELECT COUNT(c.id)
+		FROM commits c
+		JOIN users u ON c.author_login = LOWER(u.github_login)
+		WHERE u.id = ?1 AND EXISTS (
+			SELECT 1 FROM repo_registrations rr
+			WHERE rr.repo_id = c.repo_id AND rr.user_id = ?1
+		)`, userID).Scan(&totalCommits)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = s.queryRow(`SELECT COUNT(*) FROM repo_registrations WHERE user_id = ?`, userID).Scan(&trackedRepos)
+	return totalCommits, trackedRepos, err
+}
+
+func (s *sqlStore) UnlockAchievement(userID, achievement string) (bool, error) {
+	res, err := s.exec(`
+		INSERT INTO user_achievements (user_id, achievement)
+		VALUES (?, ?)
+		ON CONFLICT DO NOTHING`,
+		userID, achievement)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *sqlStore) GetUserAchievements(userID string) (map[string]time.Time, error) {
+	rows, err := s.query(`SELECT achievement, unlocked_at FROM user_achievements WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	results := make(map[string]time.Time)
+	for rows.Next() {
+		var key string
+		var unlockedAt time.Time
+		if err := rows.Scan(&key, &unlockedAt); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		results[key] = unlockedAt
+	}
+	return results, nil
+}
+
+// GetMessageTemplate returns the guild's custom template for key, or an empty
+// string when none is set, along with the guild's selected tone preset.
+func (s *sqlStore) GetMessageTemplate(guildID, key string) (text, tone string, err error) {
+	tone = defaultTone
+	err = s.queryRow(`SELECT tone FROM guild_tones WHERE guild_id = ?`, guildID).Scan(&tone)
+	if err != nil && err != sql.ErrNoRows {
+		return "", defaultTone, err
+	}
+
+	err = s.queryRow(`SELECT template FROM message_templates WHERE guild_id = ? AND key = ?`, guildID, key).Scan(&text)
+	if err == sql.ErrNoRows {
+		return "", tone, nil
+	}
+	return text, tone, err
+}
+
+func (s *sqlStore) SetMessageTemplate(guildID, key, text string) error {
+	_, err := s.exec(`
+		INSERT INTO message_templates (guild_id, key, template)
+		VALUES (?, ?, ?)
+		ON CONFLICT(guild_id, key) DO UPDATE SET template = excluded.template, updated_at = CURRENT_TIMESTAMP`,
+		guildID, key, text)
+	return err
+}
+
+func (s *sqlStore) ResetMessageTemplate(guildID, key string) error {
+	_, err := s.exec(`DELETE FROM message_templates WHERE guild_id = ? AND key = ?`, guildID, key)
+	return err
+}
+
+func (s *sqlStore) SetGuildTone(guildID, tone string) error {
+	_, err := s.exec(`
+		INSERT INTO guild_tones (guild_id, tone)
+		VALUES (?, ?)
+		ON CONFLICT(guild_id) DO UPDATE SET tone = excluded.tone`,
+		guildID, tone)
+	return err
+}
+
+func (s *sqlStore) GetGuildLocale(guildID string) (string, error) {
+	var locale sql.NullString
+	err := s.queryRow(`SELECT locale FROM guilds WHERE id = ?`, guildID).Scan(&locale)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return locale.String, err
+}
+
+func (s *sqlStore) SetGuildLocale(guildID, locale string) error {
+	_, err := s.exec(`
+		INSERT INTO guilds (id, locale)
+		VALUES (?, ?)
+		ON CONFLICT(id) DO UPDATE SET locale = excluded.locale`,
+		guildID, locale)
+	return err
+}
+
+type GuildConfig struct {
+	ID              string
+	ReportChannelID string
+	Timezone        string
+	CheckTime       string
+	Features        []string
+	LastCheckDate   string
+}
+
+// GetGuildConfig returns the stored configuration for a guild, falling back to
+// the defaults (20:00 server time, every feature enabled) when none is saved.
+func (s *sqlStore) GetGuildConfig(guildID string) (GuildConfig, error) {
+	cfg := GuildConfig{ID: guildID, CheckTime: "20:00", Features: allFeatures}
+
+	var features sql.NullString
+	err := s.queryRow(`
+		SELECT COALESCE(report_channel_id, ''), COALESCE(timezone, ''), check_time, features, COALESCE(last_check_date, '')
+		FROM guilds WHERE id = ?`, guildID).
+		Scan(&cfg.ReportChannelID, &cfg.Timezone, &cfg.CheckTime, &features, &cfg.LastCheckDate)
+	if err == sql.ErrNoRows {
+		return cfg, nil
+	}
+	if features.Valid {
+		cfg.Features = strings.FieldsFunc(features.String, func(r rune) bool { return r == ',' })
+	}
+	return cfg, err
+}
+
+func (s *sqlStore) EnsureGuild(guildID string) error {
+	_, err := s.exec(`INSERT INTO guilds (id) VALUES (?) ON CONFLICT DO NOTHING`, guildID)
+	return err
+}
+
+func (s *sqlStore) SetGuildReportChannel(guildID, channelID string) error {
+	if err := s.EnsureGuild(guildID); err != nil {
+		return err
+	}
+	_, err := s.exec(`UPDATE guilds SET report_channel_id = NULLIF(?, '') WHERE id = ?`, channelID, guildID)
+	return err
+}
+
+func (s *sqlStore) SetGuildSchedule(guildID, timezone, checkTime string) error {
+	if err := s.EnsureGuild(guildID); err != nil {
+		return err
+	}
+	_, err := s.exec(`UPDATE guilds SET timezone = ?, check_time = ? WHERE id = ?`, timezone, checkTime, guildID)
+	return err
+}
+
+func (s *sqlStore) SetGuildFeatures(guildID string, features []string) error {
+	if err := s.EnsureGuild(guildID); err != nil {
+		return err
+	}
+	_, err := s.exec(`UPDATE guilds SET features = ? WHERE id = ?`, strings.Join(features, ","), guildID)
+	return err
+}
+
+func (s *sqlStore) SetGuildLastCheckDate(guildID, day string) error {
+	if err := s.EnsureGuild(guildID); err != nil {
+		return err
+	}
+	_, err := s.exec(`UPDATE guilds SET last_check_date = ? WHERE id = ?`, day, guildID)
+	return err
+}
+
+func (s *sqlStore) GetGuildStreaks(guildID string, limit int) ([]struct {
+	UserID           string
+	Current, Longest int
+}, error) {
+	rows, err := s.query(`
+		SELECT user_id, current, longest
+		FROM streaks
+		WHERE guild_id = ? AND longest > 0
+		ORDER BY current DESC, longest DESC
+		LIMIT ?`, guildID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var results []struct {
+		UserID           string
+		Current, Longest int
+	}
+	for rows.Next() {
+		var r struct {
+			UserID           string
+			Current, Longest int
+		}
+		if err := rows.Scan(&r.UserID, &r.Current, &r.Longest); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func (s *sqlStore) GetGuildAdminRole(guildID string) (string, error) {
+	var roleID sql.NullString
+	err := s.queryRow(`SELECT admin_role_id FROM guilds WHERE id = ?`, guildID).Scan(&roleID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return roleID.String, err
+}
+
+func (s *sqlStore) SetGuildAdminRole(guildID, roleID string) error {
+	if err := s.EnsureGuild(guildID); err != nil {
+		return err
+	}
+	_, err := s.exec(`UPDATE guilds SET admin_role_id = NULLIF(?, '') WHERE id = ?`, roleID, guildID)
+	return err
+}
+
+func (s *sqlStore) LogAdminAction(guildID, userID, action, details string) error {
+	_, err := s.exec(`
+		INSERT INTO admin_audit_log (guild_id, user_id, action, details)
+		VALUES (?, ?, ?, ?)`,
+		guildID, userID, action, details)
+	return err
+}
+
+func (s *sqlStore) GetAdminAuditLog(guildID string, limit int) ([]struct {
+	UserID, Action, Details string
+	CreatedAt               time.Time
+}, error) {
+	rows, err := s.query(`
+		SELECT user_id, action, COALESCE(details, ''), created_at
+		FROM admin_audit_log
+		WHERE guild_id = ?
+		ORDER BY id DESC
+		LIMIT ?`, guildID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var results []struct {
+		UserID, Action, Details string
+		CreatedAt               time.Time
+	}
+	for rows.Next() {
+		var r struct {
+			UserID, Action, Details string
+			CreatedAt               time.Time
+		}
+		if err := rows.Scan(&r.UserID, &r.Action, &r.Details, &r.CreatedAt); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func (s *sqlStore) GetGuildStats(guildID string) (users, repos, registrations int, err error) {
+	err = s.queryRow(`
+		SELECT COUNT(DISTINCT user_id), COUNT(DISTINCT repo_id), COUNT(*)
+		FROM repo_registrations
+		WHERE guild_id = ?`, guildID).Scan(&users, &repos, &registrations)
+	return users, repos, registrations, err
+}
+
+// GetUserRegistrations lists every registration of the user across guilds.
+func (s *sqlStore) GetUserRegistrations(userID string) ([]struct{ Owner, Name, GuildID string }, error) {
+	rows, err := s.query(`
+		SELECT r.owner, r.name, rr.guild_id
+		FROM repo_registrations rr
+		JOIN repos r ON r.id = rr.repo_id
+		WHERE rr.user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var results []struct{ Owner, Name, GuildID string }
+	for rows.Next() {
+		var r struct{ Owner, Name, GuildID string }
+		if err := rows.Scan(&r.Owner, &r.Name, &r.GuildID); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// DeletedUserData counts the rows removed by deleteUserData.
+type DeletedUserData struct {
+	Registrations int64
+	Buddies       int64
+	Challenges    int64
+	Streaks       int64
+	Achievements  int64
+	Commits       int64
+}
+
+// DeleteUserData removes everything stored about a user in one transaction.
+// The admin audit log is kept, since it records actions taken in a guild and
+// belongs to that guild rather than to the user.
+func (s *sqlStore) DeleteUserData(userID string) (DeletedUserData, error) {
+	var deleted DeletedUserData
+
+	tx, err := s.begin()
+	if err != nil {
+		return deleted, err
+	}
+
+	// Commits are linked to the user only through their GitHub login, so it
+	// has to be read before the users row goes. They are deleted even on
+	// repos other members still track.
+	var login string
+	err = tx.queryRow(`SELECT COALESCE(github_login, '') FROM users WHERE id = ?`, userID).Scan(&login)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return deleted, err
+	}
+	if login != "" {
+		res, err := tx.exec(`DELETE FROM commits WHERE LOWER(author_login) = LOWER(?)`, login)
+		if err != nil {
+			tx.Rollback()
+			return deleted, err
+		}
+		deleted.Commits, _ = res.RowsAffected()
+	}
+
+	statements := []struct {
+		query string
+		count *int64
+	}{
+		{`DELETE FROM repo_registrations WHERE user_id = ?`, &deleted.Registrations},
+		{`DELETE FROM buddies WHERE requester_id = ?1 OR partner_id = ?1`, &deleted.Buddies},
+		{`DELETE FROM challenge_participants WHERE user_id = ?`, &deleted.Challenges},
+		{`DELETE FROM streaks WHERE user_id = ?`, &deleted.Streaks},
+		{`DELETE FROM user_achievements WHERE user_id = ?`, &deleted.Achievements},
+		{`DELETE FROM pending_auths WHERE user_id = ?`, nil},
+		{`DELETE FROM orphaned_webhooks WHERE user_id = ?`, nil},
+		{`DELETE FROM users WHERE id = ?`, nil},
+	}
+	for _, stmt := range statements {
+		res, err := tx.exec(stmt.query, userID)
+		if err != nil {
+			tx.Rollback()
+			return deleted, err
+		}
+		if stmt.count != nil {
+			*stmt.count, _ = res.RowsAffected()
+		}
+	}
+
+	return deleted, tx.Commit()
+}
+
+// UserAccount is the account-level data kept for a user. The token itself is
+// never exported, only whether one is stored.
+type UserAccount struct {
+	GithubLogin    string `json:"github_login,omitempty"`
+	HasGithubToken bool   `json:"has_github_token"`
+	TokenRevokedAt string `json:"token_revoked_at,omitempty"`
+	CreatedAt      string `json:"created_at"`
+}
+
+func (s *sqlStore) GetUserAccount(userID string) (UserAccount, error) {
+	var account UserAccount
+	err := s.queryRow(`
+		SELECT COALESCE(github_login, ''), github_token IS NOT NULL,
+			COALESCE(CAST(token_revoked_at AS TEXT), ''), COALESCE(CAST(created_at AS TEXT), '')
+		FROM users WHERE id = ?`, userID).
+		Scan(&account.GithubLogin, &account.HasGithubToken, &account.TokenRevokedAt, &account.CreatedAt)
+	if err == sql.ErrNoRows {
+		return account, nil
+	}
+	return account, err
+}
+
+type RegistrationRecord struct {
+	GuildID      string `json:"guild_id"`
+	Repo         string `json:"repo"`
+	ChannelID    string `json:"channel_id"`
+	RegisteredAt string `json:"registered_at"`
+}
+
+// GetUserRegistrationRecords lists the user's registrations, in one guild or
+// in all of them when guildID is empty.
+func (s *sqlStore) GetUserRegistrationRecords(userID, guildID string) ([]RegistrationRecord, error) {
+	rows, err := s.query(`
+		SELECT rr.guild_id, r.owner || '/' || r.name, rr.channel_id, COALESCE(CAST(rr.registered_at AS TEXT), '')
+		FROM repo_registrations rr
+		JOIN repos r ON r.id = rr.repo_id
+		WHERE rr.user_id = ? AND (? = '' OR rr.guild_id = ?)
+		ORDER BY rr.registered_at`, userID, guildID, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var records []RegistrationRecord
+	for rows.Next() {
+		var r RegistrationRecord
+		if err := rows.Scan(&r.GuildID, &r.Repo, &r.ChannelID, &r.RegisteredAt); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+type CommitRecord struct {
+	Repo        string `json:"repo"`
+	SHA         string `json:"sha"`
+	Author      string `json:"author"`
+	Message     string `json:"message"`
+	CommittedAt string `json:"committed_at"`
+}
+
+// GetUserCommitRecords lists the commits the user authored in their
+// registered repos, in one guild or in all of them when guildID is empty.
+// Other contributors' commits to shared repos are not the user's data.
+func (s *sqlStore) GetUserCommitRecords(userID, guildID string) ([]CommitRecord, error) {
+	rows, err := s.query(`
+		SELECT r.owner || '/' || r.name, c.sha, COALESCE(c.author, ''),
+			COALESCE(c.message, ''), COALESCE(c.committed_at, '')
+		FROM commits c
+		JOIN repos r ON r.id = c.repo_id
+		JOIN users u ON c.author_login = LOWER(u.github_login)
+		WHERE u.id = ?1 AND EXISTS (
+			SELECT 1 FROM repo_registrations rr
+			WHERE rr.repo_id = r.id AND rr.user_id = ?1 AND (?2 = '' OR rr.guild_id = ?2)
+		)
+		ORDER BY c.committed_at`, userID, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var records []CommitRecord
+	for rows.Next() {
+		var c CommitRecord
+		if err := rows.Scan(&c.Repo, &c.SHA, &c.Author, &c.Message, &c.CommittedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, c)
+	}
+	return records, rows.Err()
+}
+
+type StreakRecord struct {
+	GuildID       string `json:"guild_id"`
+	Current       int    `json:"current"`
+	Longest       int    `json:"longest"`
+	LastActiveDay string `json:"last_active_day,omitempty"`
+}
+
+func (s *sqlStore) GetUserStreakRecords(userID, guildID string) ([]StreakRecord, error) {
+	rows, err := s.query(`
+		SELECT guild_id, current, longest, COALESCE(last_active_day, '')
+		FROM streaks
+		WHERE user_id = ? AND (? = '' OR guild_id = ?)`, userID, guildID, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var records []StreakRecord
+	for rows.Next() {
+		var s StreakRecord
+		if err := rows.Scan(&s.GuildID, &s.Current, &s.Longest, &s.LastActiveDay); err != nil {
+			return nil, err
+		}
+		records = append(records, s)
+	}
+	return records, rows.Err()
+}
+
+type ChallengeRecord struct {
+	GuildID    string `json:"guild_id"`
+	Name       string `json:"name"`
+	Points     int    `json:"points"`
+	Eliminated bool   `json:"eliminated"`
+	JoinedAt   string `json:"joined_at"`
+}
+
+func (s *sqlStore) GetUserChallengeRecords(userID, guildID string) ([]ChallengeRecord, error) {
+	rows, err := s.query(`
+		SELECT c.guild_id, c.name, cp.points, cp.eliminated, COALESCE(CAST(cp.joined_at AS TEXT), '')
+		FROM challenge_participants cp
+		JOIN challenges c ON c.id = cp.challenge_id
+		WHERE cp.user_id = ? AND (? = '' OR c.guild_id = ?)
+		ORDER BY cp.joined_at`, userID, guildID, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var records []ChallengeRecord
+	for rows.Next() {
+		var c ChallengeRecord
+		if err := rows.Scan(&c.GuildID, &c.Name, &c.Points, &c.Eliminated, &c.JoinedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, c)
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Command describes how an application command is dispatched.
+type Command struct {
+	Handler func(ctx *CommandContext) error
+	// GuildOnly commands are hidden in DMs and rejected if invoked there.
+	GuildOnly bool
+	// Defer acknowledges the interaction before Handler runs, for commands
+	// that always call out to GitHub.
+	Defer bool
+	// Autocomplete suggests values for the focused option.
+	Autocomplete func(ctx *CommandContext) []*discordgo.ApplicationCommandOptionChoice
+}
+
+// maxChoices is the most suggestions Discord accepts in one response.
+const maxChoices = 25
+
+// autoDeferAfter is how long a handler may run before the interaction is
+// acknowledged on its behalf, since Discord drops it after 3 seconds.
+const autoDeferAfter = 2 * time.Second
+
+// CommandContext carries an interaction through its handler. It tracks
+// whether the interaction has been acknowledged so that replies go to the
+// right endpoint whether or not the command was deferred.
+type CommandContext struct {
+	Session     *discordgo.Session
+	DB          Store
+	Interaction *discordgo.InteractionCreate
+	// User is the invoking user, in guilds and in DMs alike.
+	User    *discordgo.User
+	GuildID string
+	Locale  discordgo.Locale
+	// Subcommand is the invoked subcommand, prefixed with its group if any.
+	Subcommand string
+
+	options map[string]*discordgo.ApplicationCommandInteractionDataOption
+
+	mu        sync.Mutex
+	deferred  bool
+	responded bool
+}
+
+// userError is an error whose message is already localized and safe to show
+// to the user as is.
+type userError struct {
+	msg string
+}
+
+func (e userError) Error() string { return e.msg }
+
+func newCommandContext(s *discordgo.Session, db Store, i *discordgo.InteractionCreate) *CommandContext {
+	ctx := &CommandContext{
+		Session:     s,
+		DB:          db,
+		Interaction: i,
+		User:        interactionUser(i),
+		GuildID:     i.GuildID,
+		Locale:      i.Locale,
+		options:     make(map[string]*discordgo.ApplicationCommandInteractionDataOption),
+	}
+	if i.Type != discordgo.InteractionApplicationCommand && i.Type != discordgo.InteractionApplicationCommandAutocomplete {
+		return ctx
+	}
+
+	opts := i.ApplicationCommandData().Options
+	for len(opts) == 1 && (opts[0].Type == discordgo.ApplicationCommandOptionSubCommandGroup || opts[0].Type == discordgo.ApplicationCommandOptionSubCommand) {
+		ctx.Subcommand = strings.TrimSpace(ctx.Subcommand + " " + opts[0].Name)
+		opts = opts[0].Options
+	}
+	for _, opt := range opts {
+		ctx.options[opt.Name] = opt
+	}
+	return ctx
+}
+
+// interactionUser returns the invoking user; i.Member is only set in guilds.
+func interactionUser(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+func dispatchCommand(s *discordgo.Session, db Store, i *discordgo.InteractionCreate) {
+	ctx := newCommandContext(s, db, i)
+	name := i.ApplicationCommandData().Name
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic handling /%s: %v\n%s", name, r, debug.Stack())
+			ctx.fail(name, fmt.Errorf("panic: %v", r))
+		}
+	}()
+
+	cmd, ok := commandHandlers[name]
+	if !ok {
+		log.Printf("No handler for command /%s", name)
+		ctx.fail(name, ctx.Errorf("command.unknown"))
+		return
+	}
+	if cmd.GuildOnly && ctx.GuildID == "" {
+		ctx.fail(name, ctx.Errorf("command.guild_only"))
+		return
+	}
+	if err := ctx.validate(name); err != nil {
+		ctx.fail(name, err)
+		return
+	}
+
+	if cmd.Defer {
+		if err := ctx.Defer(); err != nil {
+			log.Printf("Error deferring interaction: %v", err)
+			return
+		}
+	} else {
+		timer := time.AfterFunc(autoDeferAfter, func() {
+			if err := ctx.Defer(); err != nil {
+				log.Printf("Error deferring interaction: %v", err)
+			}
+		})
+		defer timer.Stop()
+	}
+
+	ctx.fail(name, cmd.Handler(ctx))
+
+	if !ctx.hasResponded() {
+		log.Printf("Handler for /%s returned without responding", name)
+	}
+}
+
+func dispatchComponent(s *discordgo.Session, db Store, i *discordgo.InteractionCreate) {
+	ctx := newCommandContext(s, db, i)
+	customID := i.MessageComponentData().CustomID
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic handling component %s: %v\n%s", customID, r, debug.Stack())
+			ctx.fail(customID, fmt.Errorf("panic: %v", r))
+		}
+	}()
+
+	ctx.fail(customID, handleComponent(ctx, customID))
+}
+
+func dispatchAutocomplete(s *discordgo.Session, db Store, i *discordgo.InteractionCreate) {
+	ctx := newCommandContext(s, db, i)
+	name := i.ApplicationCommandData().Name
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic autocompleting /%s: %v\n%s", name, r, debug.Stack())
+		}
+	}()
+
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	if cmd, ok := commandHandlers[name]; ok && cmd.Autocomplete != nil {
+		choices = append(choices, cmd.Autocomplete(ctx)...)
+	}
+	if len(choices) > maxChoices {
+		choices = choices[:maxChoices]
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{Choices: choices},
+	})
+	if err != nil {
+		log.Printf("Error responding to autocomplete: %v", err)
+	}
+}
+
+// fail reports err to the user. Localized user errors are shown verbatim,
+// anything else is logged and replaced by a generic message.
+func (ctx *CommandContext) fail(name string, err error) {
+	if err == nil {
+		return
+	}
+
+	var ue userError
+	if !errors.As(err, &ue) {
+		log.Printf("Error handling /%s: %v", name, err)
+		if ctx.hasResponded() {
+			return
+		}
+		ue.msg = tr(ctx.Locale, "command.error")
+	}
+	if err := ctx.Reply(ue.msg); err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
+// validate checks the invocation against the command definition, so
+// handlers can rely on required options being present.
+func (ctx *CommandContext) validate(name string) error {
+	var defs []*discordgo.ApplicationCommandOption
+	for _, c := range commands {
+		if c.Name == name {
+			defs = c.Options
+			break
+		}
+	}
+	for _, part := range strings.Fields(ctx.Subcommand) {
+		var next []*discordgo.ApplicationCommandOption
+		for _, def := range defs {
+			if def.Name == part {
+				next = def.Options
+				break
+			}
+		}
+		defs = next
+	}
+
+	for _, def := range defs {
+		if def.Required && !ctx.Has(def.Name) {
+			return ctx.Errorf("command.missing_option", def.Name)
+		}
+	}
+	return nil
+}
+
+// Errorf returns a user error with the localized message for key.
+func (ctx *CommandContext) Errorf(key string, args ...any) error {
+	return userError{msg: tr(ctx.Locale, key, args...)}
+}
+
+func (ctx *CommandContext) Has(name string) bool {
+	_, ok := ctx.options[name]
+	return ok
+}
+
+// Focused returns the option being typed in an autocomplete interaction.
+func (ctx *CommandContext) Focused() (name, value string) {
+	for _, opt := range ctx.options {
+		if opt.Focused {
+			return opt.Name, fmt.Sprint(opt.Value)
+		}
+	}
+	return "", ""
+}
+
+func (ctx *CommandContext) StringOption(name string) string {
+	if opt, ok := ctx.options[name]; ok {
+		return opt.StringValue()
+	}
+	return ""
+}
+
+func (ctx *CommandContext) IntOption(name string) int64 {
+	if opt, ok := ctx.options[name]; ok {
+		return opt.IntValue()
+	}
+	return 0
+}
+
+func (ctx *CommandContext) BoolOption(name string) bool {
+	if opt, ok := ctx.options[name]; ok {
+		return opt.BoolValue()
+	}
+	return false
+}
+
+// OptionID returns the snowflake of a user, channel or role option without
+// resolving it, which would cost a REST call for users outside the state.
+func (ctx *CommandContext) OptionID(name string) string {
+	if opt, ok := ctx.options[name]; ok {
+		if id, ok := opt.Value.(string); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+func (ctx *CommandContext) UserOption(name string) *discordgo.User {
+	if opt, ok := ctx.options[name]; ok {
+		return opt.UserValue(ctx.Session)
+	}
+	return nil
+}
+
+func (ctx *CommandContext) ChannelOption(name string) *discordgo.Channel {
+	if opt, ok := ctx.options[name]; ok {
+		return opt.ChannelValue(ctx.Session)
+	}
+	return nil
+}
+
+func (ctx *CommandContext) RoleOption(name string) *discordgo.Role {
+	if opt, ok := ctx.options[name]; ok {
+		return opt.RoleValue(ctx.Session, ctx.GuildID)
+	}
+	return nil
+}
+
+// Defer acknowledges the interaction with an ephemeral "thinking" state. It
+// is a no-op once the interaction has been acknowledged.
+func (ctx *CommandContext) Defer() error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if ctx.deferred || ctx.responded {
+		return nil
+	}
+	err := ctx.Session.InteractionRespond(ctx.Interaction.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err == nil {
+		ctx.deferred = true
+	}
+	return err
+}
+
+// Reply sends an ephemeral message to the invoking user: the initial
+// response, the edit of a deferred response, or a follow-up after that.
+func (ctx *CommandContext) Reply(content string) error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	var err error
+	switch {
+	case ctx.responded:
+		_, err = ctx.Session.FollowupMessageCreate(ctx.Interaction.Interaction, true, &discordgo.WebhookParams{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		})
+	case ctx.deferred:
+		_, err = ctx.Session.InteractionResponseEdit(ctx.Interaction.Interaction, &discordgo.WebhookEdit{Content: &content})
+	default:
+		err = ctx.Session.InteractionRespond(ctx.Interaction.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: content,
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+	}
+	if err == nil {
+		ctx.responded = true
+	}
+	return err
+}
+
+// Replyf replies with the localized message for key.
+func (ctx *CommandContext) Replyf(key string, args ...any) error {
+	return ctx.Reply(tr(ctx.Locale, key, args...))
+}
+
+// Respond sends a custom response, such as a public message with buttons.
+// A deferred response is already ephemeral, so in that case the placeholder
+// is removed and the message is sent as a follow-up instead.
+func (ctx *CommandContext) Respond(resp *discordgo.InteractionResponse) error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	var err error
+	if ctx.deferred || ctx.responded {
+		if !ctx.responded {
+			if err := ctx.Session.InteractionResponseDelete(ctx.Interaction.Interaction); err != nil {
+				log.Printf("Error deleting deferred response: %v", err)
+			}
+		}
+		_, err = ctx.Session.FollowupMessageCreate(ctx.Interaction.Interaction, true, &discordgo.WebhookParams{
+			Content:    resp.Data.Content,
+			Components: resp.Data.Components,
+			Flags:      resp.Data.Flags,
+		})
+	} else {
+		err = ctx.Session.InteractionRespond(ctx.Interaction.Interaction, resp)
+	}
+	if err == nil {
+		ctx.responded = true
+	}
+	return err
+}
+
+func (ctx *CommandContext) hasResponded() bool {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.responded
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// Store is the persistence layer. Everything the bot keeps goes through it,
+// so the same code runs on SQLite or on PostgreSQL.
+type Store interface {
+	Migrate() error
+	Ping() error
+	Close() error
+	Backup(path string) error
+
+	RegisterRepo(userID, owner, repo, guildID, channeltID string) error
+	UnregisterRepo(userID, owner, repo, guildID string) (webhookID int64, shouldDelete bool, err error)
+	IsRepoRegistered(userID, owner, repo, guildID string) (bool, error)
+	GetGuildRegisteredUsers(guildID string) ([]struct{ UserID, ChannelID string }, error)
+	GetActiveGuildIDs() ([]string, error)
+	GetRegistrationsWithoutGuild() ([]struct {
+		ID        int64
+		ChannelID string
+	}, error)
+	SetRegistrationGuild(registrationID int64, guildID string) error
+	GetReposByUserID(userID, guildID string) ([]struct{ Owner, Name, ChannelID string }, error)
+	GetUserIDsByRepo(owner, repo string) ([]struct{ UserID, GuildID, ChannelID string }, error)
+	GetUserRegistrations(userID string) ([]struct{ Owner, Name, GuildID string }, error)
+
+	StoreGithubToken(userID, accessToken, login string) error
+	GetGithubToken(userID string) (string, error)
+	MarkGithubTokenRevoked(userID string) (bool, error)
+	GetTokensWithoutGithubLogin() ([]struct{ UserID, Token string }, error)
+	GetUserIDsByGithubLogin(login string) ([]string, error)
+	GetUserGuildIDs(userID string) ([]string, error)
+
+	CreatePendingAuth(state string, pending PendingAuth) error
+	ConsumePendingAuth(state string, now time.Time) (PendingAuth, error)
+	PurgeExpiredPendingAuths(now time.Time) (int64, error)
+
+	GetRepoWebhookID(owner, repo string) (int64, error)
+	StoreWebhookID(owner, repo string, webhookID int64, secret string) error
+	GetTrackedRepos() ([]TrackedRepo, error)
+	AddOrphanedWebhook(owner, repo string, webhookID int64, userID string) error
+	GetOrphanedWebhooks() ([]OrphanedWebhook, error)
+	RemoveOrphanedWebhook(id int64) error
+
+	UpsertInstallation(installationID int64, account string) error
+	DeleteInstallation(installationID int64) error
+	SetInstallationSuspended(installationID int64, suspended bool) error
+	ClearInstallationRepos(installationID int64) error
+	SetRepoInstallation(owner, repo string, installationID int64) error
+	GetRepoInstallation(owner, repo string) (int64, string, error)
+
+	RequestBuddy(requesterID, partnerID string) error
+	RespondToBuddyRequest(requesterID, partnerID string, accept bool) error
+	GetBuddyID(userID string) (string, error)
+	RemoveBuddy(userID string) (string, error)
+
+	CreateChallenge(c Challenge) error
+	GetChallenge(guildID, name string) (Challenge, error)
+	GetUnfinishedChallenges(guildID string) ([]Challenge, error)
+	MarkChallengeEvaluated(challengeID int64, day string) error
+	FinishChallenge(challengeID int64) error
+	JoinChallenge(challengeID int64, userID string) error
+	LeaveChallenge(challengeID int64, userID string) (bool, error)
+	GetChallengeParticipants(challengeID int64) ([]ChallengeParticipant, error)
+	RecordChallengeDay(challengeID int64, userID string, passed, eliminate bool) error
+
+	UpdateStreak(userID, guildID string, day time.Time, active bool) (int, error)
+	GetStreak(userID, guildID string) (int, error)
+	GetGuildStreaks(guildID string, limit int) ([]struct {
+		UserID           string
+		Current, Longest int
+	}, error)
+	SetStreakRole(guildID string, threshold int, roleID string) error
+	RemoveStreakRole(guildID string, threshold int) (bool, error)
+	GetStreakRoles(guildID string) ([]struct {
+		Threshold int
+		RoleID    string
+	}, error)
+
+	StoreCommits(owner, repo string, commits []PushCommit) error
+	GetUserCommitStats(userID string) (totalCommits, trackedRepos int, err error)
+	UnlockAchievement(userID, achievement string) (bool, error)
+	GetUserAchievements(userID string) (map[string]time.Time, error)
+
+	GetMessageTemplate(guildID, key string) (text, tone string, err error)
+	SetMessageTemplate(guildID, key, text string) error
+	ResetMessageTemplate(guildID, key string) error
+	SetGuildTone(guildID, tone string) error
+
+	EnsureGuild(guildID string) error
+	GetGuildConfig(guildID string) (GuildConfig, error)
+	GetGuildLocale(guildID string) (string, error)
+	SetGuildLocale(guildID, locale string) error
+	SetGuildReportChannel(guildID, channelID string) error
+	SetGuildSchedule(guildID, timezone, checkTime string) error
+	SetGuildFeatures(guildID string, features []string) error
+	SetGuildLastCheckDate(guildID, day string) error
+	GetGuildAdminRole(guildID string) (string, error)
+	SetGuildAdminRole(guildID, roleID string) error
+	GetGuildStats(guildID string) (users, repos, registrations int, err error)
+	LogAdminAction(guildID, userID, action, details string) error
+	GetAdminAuditLog(guildID string, limit int) ([]struct {
+		UserID, Action, Details string
+		CreatedAt               time.Time
+	}, error)
+
+	DeleteUserData(userID string) (DeletedUserData, error)
+	GetUserAccount(userID string) (UserAccount, error)
+	GetUserRegistrationRecords(userID, guildID string) ([]RegistrationRecord, error)
+	GetUserCommitRecords(userID, guildID string) ([]CommitRecord, error)
+	GetUserStreakRecords(userID, guildID string) ([]StreakRecord, error)
+	GetUserChallengeRecords(userID, guildID string) ([]ChallengeRecord, error)
+}
+
+// dialect holds what differs between the SQL databases we run on. Queries
+// are written once in SQL both understand, with ? placeholders.
+type dialect struct {
+	name   string
+	driver string
+	// migrations is the directory holding this dialect's schema changes.
+	migrations string
+	// numberedParams rewrites ? placeholders to $1, $2, ... for drivers
+	// that only accept those.
+	numberedParams bool
+}
+
+var (
+	sqliteDialect   = dialect{name: "sqlite", driver: "sqlite", migrations: "migrations"}
+	postgresDialect = dialect{name: "postgres", driver: "postgres", migrations: "migrations/postgres", numberedParams: true}
+)
+
+// sqlStore implements Store on top of database/sql for either dialect.
+type sqlStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+const defaultDatabasePath = "./bot.db"
+
+// openStore connects to PostgreSQL when DATABASE_URL is set and to the
+// SQLite file at DATABASE_PATH (./bot.db by default) otherwise.
+func openStore() (*sqlStore, error) {
+	if DatabaseURL != "" {
+		return newStore(postgresDialect, DatabaseURL)
+	}
+	return newStore(sqliteDialect, sqliteDSN(sqlitePath()))
+}
+
+func sqlitePath() string {
+	if DatabasePath != "" {
+		return DatabasePath
+	}
+	return defaultDatabasePath
+}
+
+// sqliteDSN adds the pragmas every connection needs to the database path.
+// WAL lets the checks read while webhooks write, busy_timeout makes writers
+// wait for each other instead of failing with SQLITE_BUSY, and immediate
+// transactions take the write lock up front so they never have to upgrade
+// (which busy_timeout can't retry). foreign_keys is off by default in SQLite.
+func sqliteDSN(path string) string {
+	params := url.Values{}
+	params.Add("_pragma", "busy_timeout("+envOrDefault("SQLITE_BUSY_TIMEOUT_MS", "5000")+")")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "foreign_keys(ON)")
+	// NORMAL is safe in WAL mode: a power loss can only lose the last
+	// commits, never corrupt the file.
+	params.Add("_pragma", "synchronous("+envOrDefault("SQLITE_SYNCHRONOUS", "NORMAL")+")")
+	params.Set("_txlock", "immediate")
+	return path + "?" + params.Encode()
+}
+
+func newStore(d dialect, dsn string) (*sqlStore, error) {
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := configurePool(db, d); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqlStore{db: db, dialect: d}, nil
+}
+
+// configurePool sizes the connection pool. SQLite has a single writer, so a
+// few connections are enough for concurrent reads; DB_MAX_OPEN_CONNS and
+// DB_CONN_MAX_IDLE_TIME override the defaults.
+func configurePool(db *sql.DB, d dialect) error {
+	maxOpen := 4
+	if d.name == "postgres" {
+		maxOpen = 10
+	}
+	if v := os.Getenv("DB_MAX_OPEN_CONNS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid DB_MAX_OPEN_CONNS %q", v)
+		}
+		maxOpen = n
+	}
+
+	maxIdleTime, err := time.ParseDuration(envOrDefault("DB_CONN_MAX_IDLE_TIME", "5m"))
+	if err != nil {
+		return fmt.Errorf("invalid DB_CONN_MAX_IDLE_TIME: %w", err)
+	}
+
+	db.SetMaxOpenConns(maxOpen)
+	db.SetMaxIdleConns(maxOpen)
+	db.SetConnMaxIdleTime(maxIdleTime)
+	return nil
+}
+
+func envOrDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// CheckIntegrity runs SQLite's integrity check and fails if the file is
+// corrupt. Rows that break a foreign key are only logged: they were written
+// before foreign keys were enforced and the bot can still run with them.
+// PostgreSQL enforces its constraints itself, so there is nothing to check.
+func (s *sqlStore) CheckIntegrity() error {
+	if s.dialect.name != "sqlite" {
+		return nil
+	}
+
+	problems, err := s.integrityProblems()
+	if err != nil {
+		return fmt.Errorf("failed to run integrity check: %w", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("database is corrupt: %s", strings.Join(problems, "; "))
+	}
+
+	rows, err := s.query(`SELECT "table", COUNT(*) FROM pragma_foreign_key_check GROUP BY "table"`)
+	if err != nil {
+		return fmt.Errorf("failed to run foreign key check: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+	for rows.Next() {
+		var table string
+		var count int
+		if err := rows.Scan(&table, &count); err != nil {
+			return fmt.Errorf("failed to read foreign key check: %w", err)
+		}
+		log.Printf("Warning: %s has %d foreign key violations", table, count)
+	}
+	return rows.Err()
+}
+
+// integrityProblems returns what PRAGMA integrity_check reports, or nothing
+// when the database is sound.
+func (s *sqlStore) integrityProblems() ([]string, error) {
+	rows, err := s.query(`PRAGMA integrity_check`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return nil, err
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	return problems, rows.Err()
+}
+
+func (s *sqlStore) Ping() error {
+	return s.db.Ping()
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// rebind rewrites a query's placeholders for the store's dialect. Numbered
+// placeholders like ?2 keep their number, bare ones are numbered in order.
+func (s *sqlStore) rebind(query string) string {
+	if !s.dialect.numberedParams {
+		return query
+	}
+
+	var sb strings.Builder
+	n := 0
+	inString := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			inString = !inString
+		case c == '?' && !inString:
+			j := i + 1
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				j++
+			}
+			sb.WriteByte('$')
+			if j > i+1 {
+				sb.WriteString(query[i+1 : j])
+			} else {
+				n++
+				sb.WriteString(strconv.Itoa(n))
+			}
+			i = j - 1
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+func (s *sqlStore) exec(query string, args ...any) (sql.Result, error) {
+	return s.db.Exec(s.rebind(query), args...)
+}
+
+func (s *sqlStore) query(query string, args ...any) (*sql.Rows, error) {
+	return s.db.Query(s.rebind(query), args...)
+}
+
+func (s *sqlStore) queryRow(query string, args ...any) *sql.Row {
+	return s.db.QueryRow(s.rebind(query), args...)
+}
+
+func (s *sqlStore) begin() (*storeTx, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &storeTx{Tx: tx, store: s}, nil
+}
+
+// storeTx is a transaction whose queries are rebound like the store's.
+type storeTx struct {
+	*sql.Tx
+	store *sqlStore
+}
+
+func (tx *storeTx) exec(query string, args ...any) (sql.Result, error) {
+	return tx.Exec(tx.store.rebind(query), args...)
+}
+
+func (tx *storeTx) query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Query(tx.store.rebind(query), args...)
+}
+
+func (tx *storeTx) queryRow(query string, args ...any) *sql.Row {
+	return tx.QueryRow(tx.store.rebind(query), args...)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// botCanManageRole reports whether roleID can be given to members and the
+// bot's highest role sits above it, which Discord requires before the bot
+// may assign or remove it.
+func botCanManageRole(dg *discordgo.Session, guildID, roleID string) (bool, error) {
+	// @everyone shares the guild's ID and integration roles are managed by
+	// Discord, so neither can be given to members.
+	if roleID == guildID {
+		return false, nil
+	}
+
+	roles, err := dg.GuildRoles(guildID)
+	if err != nil {
+		return false, err
+	}
+	member, err := dg.GuildMember(guildID, dg.State.User.ID)
+	if err != nil {
+		return false, err
+	}
+
+	positions := make(map[string]int, len(roles))
+	var target *discordgo.Role
+	for _, role := range roles {
+		positions[role.ID] = role.Position
+		if role.ID == roleID {
+			target = role
+		}
+	}
+
+	if target == nil {
+		return false, fmt.Errorf("role %s not found", roleID)
+	}
+	if target.Managed {
+		return false, nil
+	}
+
+	highest := 0
+	for _, id := range member.Roles {
+		highest = max(highest, positions[id])
+	}
+	return highest > target.Position, nil
+}
+
+func applyStreakRoles(dg *discordgo.Session, db Store, guildID, userID string, streak int) {
+	if guildID == "" {
+		return
+	}
+
+	roles, err := db.GetStreakRoles(guildID)
+	if err != nil {
+		log.Printf("Error getting streak roles for guild %s: %v", guildID, err)
+		return
+	}
+
+	if len(roles) == 0 {
+		return
+	}
+
+	// The bot has no members intent, so the state cache can't be trusted
+	// to know the member's current roles.
+	member, err := dg.GuildMember(guildID, userID)
+	if err != nil {
+		log.Printf("Error getting member %s in guild %s: %v", userID, guildID, err)
+		return
+	}
+
+	// Only roles that actually change are sent to Discord, so the daily
+	// check doesn't make a request for every mapped role of every member.
+	for _, r := range roles {
+		want := streak >= r.Threshold
+		if want == slices.Contains(member.Roles, r.RoleID) {
+			continue
+		}
+		if want {
+			err = dg.GuildMemberRoleAdd(guildID, userID, r.RoleID)
+		} else {
+			err = dg.GuildMemberRoleRemove(guildID, userID, r.RoleID)
+		}
+
+		var restErr *discordgo.RESTError
+		if errors.As(err, &restErr) && restErr.Response != nil && restErr.Response.StatusCode == http.StatusForbidden {
+			log.Printf("Missing permission to manage role %s in guild %s, is the bot's role above it?", r.RoleID, guildID)
+		} else if err != nil {
+			log.Printf("Error updating streak role %s for user %s: %v", r.RoleID, userID, err)
+		}
+	}
+}
+
+func handleStreakRoleCommand(ctx *CommandContext) error {
+	switch ctx.Subcommand {
+	case "set":
+		threshold := int(ctx.IntOption("threshold"))
+		role := ctx.RoleOption("role")
+
+		ok, err := botCanManageRole(ctx.Session, ctx.GuildID, role.ID)
+		if err != nil {
+			return ctx.Errorf("streakrole.permission_error", err)
+		}
+		if !ok && (role.Managed || role.ID == ctx.GuildID) {
+			return ctx.Errorf("streakrole.role_unassignable", role.ID)
+		}
+		if !ok {
+			return ctx.Errorf("streakrole.role_too_high", role.ID)
+		}
+
+		if err := ctx.DB.SetStreakRole(ctx.GuildID, threshold, role.ID); err != nil {
+			return ctx.Errorf("streakrole.save_error", err)
+		}
+		return ctx.Replyf("streakrole.saved", threshold, role.ID)
+
+	case "remove":
+		threshold := int(ctx.IntOption("threshold"))
+		removed, err := ctx.DB.RemoveStreakRole(ctx.GuildID, threshold)
+		if err != nil {
+			return ctx.Errorf("streakrole.remove_error", err)
+		}
+		if !removed {
+			return ctx.Errorf("streakrole.not_mapped", threshold)
+		}
+		return ctx.Replyf("streakrole.removed", threshold)
+
+	case "list":
+		roles, err := ctx.DB.GetStreakRoles(ctx.GuildID)
+		if err != nil {
+			return ctx.Errorf("streakrole.load_error", err)
+		}
+		if len(roles) == 0 {
+			return ctx.Replyf("streakrole.none")
+		}
+
+		var sb strings.Builder
+		sb.WriteString(tr(ctx.Locale, "streakrole.header") + "\n")
+		for _, r := range roles {
+			sb.WriteString(tr(ctx.Locale, "streakrole.entry", r.Threshold, r.RoleID) + "\n")
+		}
+		return ctx.Reply(sb.String())
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// UnlinkResult summarizes what unlinkUser removed.
+type UnlinkResult struct {
+	DeletedUserData
+	HadToken        bool
+	TokenRevoked    bool
+	WebhooksDeleted int
+	// WebhooksLeft are "owner/repo" names whose hook could not be deleted
+	// and has to be removed by hand.
+	WebhooksLeft []string
+}
+
+func handleUnlinkCommand(ctx *CommandContext) error {
+	regs, err := ctx.DB.GetUserRegistrations(ctx.User.ID)
+	if err != nil {
+		return ctx.Errorf("unlink.error", err)
+	}
+
+	return ctx.Respond(&discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: tr(ctx.Locale, "unlink.confirm", len(regs)),
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.Button{
+							Label:    tr(ctx.Locale, "unlink.confirm_button"),
+							Style:    discordgo.DangerButton,
+							CustomID: "unlink_confirm:" + ctx.User.ID,
+						},
+						discordgo.Button{
+							Label:    tr(ctx.Locale, "unlink.cancel_button"),
+							Style:    discordgo.SecondaryButton,
+							CustomID: "unlink_cancel:" + ctx.User.ID,
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+// handleUnlinkButton answers the confirmation prompt. The prompt is
+// ephemeral, but the user ID in the custom ID is checked anyway.
+func handleUnlinkButton(ctx *CommandContext, confirm bool, userID string) error {
+	if userID != ctx.User.ID {
+		return nil
+	}
+
+	content := tr(ctx.Locale, "unlink.cancelled")
+	if confirm {
+		content = tr(ctx.Locale, "unlink.in_progress")
+	}
+	err := ctx.Respond(&discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Components: []discordgo.MessageComponent{},
+		},
+	})
+	if err != nil || !confirm {
+		return err
+	}
+
+	result, err := unlinkUser(ctx.DB, userID)
+	if err != nil {
+		return ctx.Errorf("unlink.error", err)
+	}
+	log.Printf("Unlinked user %s", userID)
+	return ctx.Reply(unlinkSummary(ctx.Locale, result))
+}
+
+// unlinkUser deletes a user's webhooks where they are the last registrant,
+// revokes their GitHub token and removes all their rows. Hooks are deleted
+// before the token is revoked, since the token is what allows it.
+func unlinkUser(db Store, userID string) (UnlinkResult, error) {
+	var result UnlinkResult
+
+	token, err := db.GetGithubToken(userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return result, err
+	}
+	result.HadToken = token != ""
+
+	regs, err := db.GetUserRegistrations(userID)
+	if err != nil {
+		return result, err
+	}
+
+	deleteHook := func(owner, repo string, webhookID int64) {
+		repoKey := fmt.Sprintf("%s/%s", owner, repo)
+		if token == "" {
+			result.WebhooksLeft = append(result.WebhooksLeft, repoKey)
+			return
+		}
+		if err := deleteGitHubWebhook(token, owner, repo, webhookID); err != nil {
+			log.Printf("Error deleting GitHub webhook for %s: %v", repoKey, err)
+			result.WebhooksLeft = append(result.WebhooksLeft, repoKey)
+			return
+		}
+		result.WebhooksDeleted++
+	}
+
+	for _, reg := range regs {
+		webhookID, shouldDelete, err := db.UnregisterRepo(userID, reg.Owner, reg.Name, reg.GuildID)
+		if err != nil {
+			return result, err
+		}
+		result.Registrations++
+		if shouldDelete && webhookID != 0 {
+			deleteHook(reg.Owner, reg.Name, webhookID)
+		}
+	}
+
+	// Hooks that failed to delete on an earlier /unregister can only be
+	// retried with this token, so try them one last time.
+	orphans, err := db.GetOrphanedWebhooks()
+	if err != nil {
+		log.Printf("Error getting orphaned webhooks: %v", err)
+	}
+	for _, o := range orphans {
+		if o.UserID == userID {
+			deleteHook(o.Owner, o.Name, o.WebhookID)
+		}
+	}
+
+	if token != "" && GithubClientID != "" && GithubSecret != "" {
+		if err := revokeGitHubToken(token); err != nil {
+			log.Printf("Error revoking GitHub token for user %s: %v", userID, err)
+		} else {
+			result.TokenRevoked = true
+		}
+	}
+
+	deleted, err := db.DeleteUserData(userID)
+	if err != nil {
+		return result, err
+	}
+	deleted.Registrations += result.Registrations
+	result.DeletedUserData = deleted
+
+	githubReposCacheMu.Lock()
+	delete(githubReposCache, userID)
+	githubReposCacheMu.Unlock()
+
+	return result, nil
+}
+
+func unlinkSummary(locale discordgo.Locale, result UnlinkResult) string {
+	lines := []string{tr(locale, "unlink.done")}
+
+	switch {
+	case result.TokenRevoked:
+		lines = append(lines, tr(locale, "unlink.token_revoked"))
+	case result.HadToken:
+		lines = append(lines, tr(locale, "unlink.token_not_revoked"))
+	default:
+		lines = append(lines, tr(locale, "unlink.no_token"))
+	}
+
+	lines = append(lines,
+		tr(locale, "unlink.registrations", result.Registrations),
+		tr(locale, "unlink.webhooks", result.WebhooksDeleted),
+	)
+	if len(result.WebhooksLeft) > 0 {
+		lines = append(lines, tr(locale, "unlink.webhooks_left", strings.Join(result.WebhooksLeft, ", ")))
+	}
+	lines = append(lines, tr(locale, "unlink.history", result.Streaks, result.Achievements, result.Buddies, result.Challenges))
+	lines = append(lines, tr(locale, "unlink.commits", result.Commits))
+
+	return strings.Join(lines, "\n")
+}
@@ -1,12 +1,13 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"regexp"
 	"strings"
 	"time"
 
@@ -21,15 +22,110 @@ func sendMessage(dg *discordgo.Session, channelID, message string) {
 	log.Printf("Sent message: %s", message)
 }
 
-func processUserCommits(db *sql.DB, dg *discordgo.Session, userID, channelID string) {
-	commitStatus, err := checkDailyCommits(db, userID)
+var (
+	githubOwnerPattern = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]{0,38})$`)
+	githubRepoPattern  = regexp.MustCompile(`^[A-Za-z0-9._-]{1,100}$`)
+)
+
+// parseRepoInput accepts owner/repo as well as GitHub URLs in HTTPS or SSH
+// form, with or without a .git suffix or a trailing path such as /tree/main.
+func parseRepoInput(input string) (owner, repo string, ok bool) {
+	s := strings.TrimSpace(input)
+	isURL := false
+
+	if rest, found := strings.CutPrefix(s, "git@github.com:"); found {
+		s, isURL = rest, true
+	} else if _, rest, found := strings.Cut(s, "://"); found {
+		s = strings.TrimPrefix(rest, "www.")
+		if s, found = strings.CutPrefix(s, "github.com/"); !found {
+			return "", "", false
+		}
+		isURL = true
+	} else if rest, found := strings.CutPrefix(s, "github.com/"); found {
+		s, isURL = rest, true
+	}
+
+	s, _, _ = strings.Cut(s, "?")
+	s, _, _ = strings.Cut(s, "#")
+	parts := strings.Split(strings.Trim(s, "/"), "/")
+	if len(parts) < 2 || (!isURL && len(parts) != 2) {
+		return "", "", false
+	}
+
+	owner, repo = parts[0], strings.TrimSuffix(parts[1], ".git")
+	if !githubOwnerPattern.MatchString(owner) || !githubRepoPattern.MatchString(repo) || repo == "." || repo == ".." {
+		return "", "", false
+	}
+	return owner, repo, true
+}
+
+func guildIDForChannel(dg *discordgo.Session, channelID string) string {
+	channel, err := dg.State.Channel(channelID)
+	if err != nil {
+		channel, err = dg.Channel(channelID)
+		if err != nil {
+			log.Printf("Error looking up channel %s: %v", channelID, err)
+			return ""
+		}
+	}
+	return channel.GuildID
+}
+
+func processUserCommits(db Store, dg *discordgo.Session, guildID, userID, channelID string, final bool) {
+	report, err := buildDailyReport(db, dg, guildID, userID, final)
 	if err != nil {
 		log.Printf("Error checking daily commits: %v", err)
 		return
 	}
 
+	sendMessage(dg, channelID, report)
+}
+
+// settleUserStreak settles the user's streak without posting a report.
+func settleUserStreak(db Store, dg *discordgo.Session, cfg GuildConfig, userID string) {
+	commitStatus, err := checkDailyCommits(db, dg, userID, cfg.ID)
+	if err != nil {
+		log.Printf("Error checking daily commits: %v", err)
+		return
+	}
+	committed := false
+	for _, hasCommit := range commitStatus {
+		committed = committed || hasCommit
+	}
+	settleStreak(db, dg, cfg, userID, committed)
+}
+
+// settleStreak records whether the user committed today and syncs their
+// streak roles. It runs at the scheduled check whether or not the guild
+// posts daily reports.
+func settleStreak(db Store, dg *discordgo.Session, cfg GuildConfig, userID string, committed bool) int {
+	streak, err := db.UpdateStreak(userID, cfg.ID, time.Now().In(cfg.Location()), committed)
+	if err != nil {
+		log.Printf("Error updating streak for user %s: %v", userID, err)
+		return 0
+	}
+	if cfg.Enabled("streak_roles") {
+		applyStreakRoles(dg, db, cfg.ID, userID, streak)
+	}
+	return streak
+}
+
+// buildDailyReport checks the user's repos and renders the daily breakdown.
+// The scheduled check passes final so that streaks and streak roles are
+// updated and the buddy is pinged; on-demand checks only preview the day.
+func buildDailyReport(db Store, dg *discordgo.Session, guildID, userID string, final bool) (string, error) {
+	commitStatus, err := checkDailyCommits(db, dg, userID, guildID)
+	if err != nil {
+		return "", err
+	}
+
+	cfg, err := db.GetGuildConfig(guildID)
+	if err != nil {
+		log.Printf("Error getting config for guild %s: %v", guildID, err)
+	}
+
 	var messageBuilder strings.Builder
-	messageBuilder.WriteString(fmt.Sprintf("Daily commit check for <@%s>:\n", userID))
+	messageBuilder.WriteString(renderMessage(db, dg, guildID, "daily_header", MessageData{User: fmt.Sprintf("<@%s>", userID)}) + "\n")
 
 	totalCommitsToday := 0
 
@@ -42,47 +138,162 @@ func processUserCommits(db *sql.DB, dg *discordgo.Session, userID, channelID str
 		messageBuilder.WriteString(fmt.Sprintf("%s %s\n", repo, emoji))
 	}
 
+	var streak int
+	if final {
+		streak = settleStreak(db, dg, cfg, userID, totalCommitsToday > 0)
+	} else {
+		streak, err = db.GetStreak(userID, guildID)
+		if err != nil {
+			log.Printf("Error getting streak for user %s: %v", userID, err)
+		}
+	}
+
+	data := MessageData{User: fmt.Sprintf("<@%s>", userID), Count: totalCommitsToday}
 	if totalCommitsToday > 0 {
-		messageBuilder.WriteString(fmt.Sprintf("Great job <@%s>! You made %d commits today! Keep it up! 🎉", userID, totalCommitsToday))
+		messageBuilder.WriteString(renderMessage(db, dg, guildID, "daily_success", data))
 	} else {
-		messageBuilder.WriteString(fmt.Sprintf("Ur a bum <@%s> get on it 😡", userID))
+		messageBuilder.WriteString(renderMessage(db, dg, guildID, "daily_failure", data))
+	}
+	if streak > 0 {
+		messageBuilder.WriteString("\n" + renderMessage(db, dg, guildID, "daily_streak", MessageData{User: data.User, Count: streak}))
 	}
 
-	sendMessage(dg, channelID, messageBuilder.String())
+	buddyID := ""
+	if cfg.Enabled("buddies") {
+		buddyID, err = db.GetBuddyID(userID)
+		if err != nil {
+			log.Printf("Error getting buddy for user %s: %v", userID, err)
+		}
+	}
+	if buddyID != "" {
+		buddyStatus, err := checkDailyCommits(db, dg, buddyID, "")
+		if err != nil {
+			log.Printf("Error checking daily commits for buddy %s: %v", buddyID, err)
+		} else {
+			buddyCommits := 0
+			for _, hasCommit := range buddyStatus {
+				if hasCommit {
+					buddyCommits++
+				}
+			}
+			messageBuilder.WriteString("\n" + renderMessage(db, dg, guildID, "buddy_status", MessageData{
+				User:  data.User,
+				Buddy: fmt.Sprintf("<@%s>", buddyID),
+				Count: buddyCommits,
+				Total: len(buddyStatus),
+			}))
+		}
+
+		if final && totalCommitsToday == 0 {
+			data.Buddy = fmt.Sprintf("<@%s>", buddyID)
+			messageBuilder.WriteString("\n" + renderMessage(db, dg, guildID, "buddy_missed", data))
+		}
+	}
+
+	return messageBuilder.String(), nil
+}
+
+// Challenge evaluations postponed by a GitHub failure are retried after
+// challengeRetryMin, doubling up to challengeRetryMax, so an outage doesn't
+// make every participant's repos get queried every minute.
+const (
+	challengeRetryMin = 5 * time.Minute
+	challengeRetryMax = time.Hour
+)
+
+// challengeRetry is when a guild's postponed challenges are tried next.
+type challengeRetry struct {
+	day   string
+	at    time.Time
+	delay time.Duration
+	done  bool
+}
+
+func (r challengeRetry) next(now time.Time, postponed bool) challengeRetry {
+	if !postponed {
+		r.done = true
+		return r
+	}
+	r.delay = min(max(2*r.delay, challengeRetryMin), challengeRetryMax)
+	r.at = now.Add(r.delay)
+	return r
 }
 
-func scheduleDailyChecks(db *sql.DB, dg *discordgo.Session) {
+// scheduleDailyChecks wakes up every minute and runs the daily check for each
+// guild whose configured check time has passed in its own timezone. The last
+// check date is persisted so a restart never runs a guild twice in one day.
+func scheduleDailyChecks(db Store, dg *discordgo.Session) {
+	retries := make(map[string]challengeRetry)
 	for {
 		now := time.Now()
-		target := time.Date(now.Year(), now.Month(), now.Day(), 20, 0, 0, 0, now.Location())
-		if now.After(target) {
-			target = target.Add(24 * time.Hour)
-		}
-
-		log.Printf("Next daily check scheduled at: %s", target.Format(time.RFC1123))
-		time.Sleep(time.Until(target))
 
-		users, err := getAllRegisteredUserIDs(db)
+		guildIDs, err := db.GetActiveGuildIDs()
 		if err != nil {
-			log.Printf("Error getting registered user IDs: %v", err)
-			continue
+			log.Printf("Error getting guilds: %v", err)
 		}
 
-		for _, user := range users {
-			processUserCommits(db, dg, user.UserID, user.ChannelID)
+		for _, guildID := range guildIDs {
+			cfg, err := db.GetGuildConfig(guildID)
+			if err != nil {
+				log.Printf("Error getting config for guild %s: %v", guildID, err)
+				continue
+			}
+
+			due := cfg.dueAt(now)
+			today := due.Format(time.DateOnly)
+			if now.Before(due) {
+				continue
+			}
+			retry := retries[guildID]
+			if retry.day != today {
+				retry = challengeRetry{day: today}
+			}
+			if cfg.LastCheckDate == today {
+				// Challenges postponed because GitHub failed are retried
+				// with a backoff until they are evaluated. After a restart
+				// they are tried once, evaluated ones being skipped.
+				if cfg.Enabled("challenges") && !retry.done && !now.Before(retry.at) {
+					retries[guildID] = retry.next(now, evaluateChallenges(db, dg, guildID, now.In(cfg.Location())))
+				}
+				continue
+			}
+
+			log.Printf("Running daily check for guild %s", guildID)
+			retries[guildID] = retry.next(now, runGuildCheck(db, dg, cfg, now, true))
+
+			if err := db.SetGuildLastCheckDate(guildID, today); err != nil {
+				log.Printf("Error recording check date for guild %s: %v", guildID, err)
+			}
 		}
 
+		time.Sleep(time.Until(now.Truncate(time.Minute).Add(time.Minute)))
+	}
+}
+
+// purgePendingAuths removes expired /register states every few minutes.
+func purgePendingAuths(db Store) {
+	for {
+		n, err := db.PurgeExpiredPendingAuths(time.Now())
+		if err != nil {
+			log.Printf("Error purging expired pending auths: %v", err)
+		} else if n > 0 {
+			log.Printf("Purged %d expired pending auths", n)
+		}
+		time.Sleep(5 * time.Minute)
 	}
 }
 
-func checkDailyCommits(db *sql.DB, userID string) (map[string]bool, error) {
-	repos, err := getReposByUserID(db, userID)
+// checkDailyCommits reports, for each of the user's repos registered in
+// guildID (or in any guild when empty), whether it had a commit in the last
+// 24 hours.
+func checkDailyCommits(db Store, dg *discordgo.Session, userID, guildID string) (map[string]bool, error) {
+	repos, err := db.GetReposByUserID(userID, guildID)
 	if err != nil {
 		log.Printf("Error getting repo by user ID: %v", err)
 		return nil, err
 	}
 
-	token, err := getGithubToken(db, userID)
+	token, err := db.GetGithubToken(userID)
 	if err != nil {
 		log.Printf("Error getting GitHub token: %v", err)
 	}
@@ -92,40 +303,81 @@ func checkDailyCommits(db *sql.DB, userID string) (map[string]bool, error) {
 
 	for _, repo := range repos {
 		repoKey := fmt.Sprintf("%s/%s", repo.Owner, repo.Name)
-		URL := fmt.Sprintf("https://api.github.com/repos/%s/commits?since=%s&per_page=1", repoKey, since)
 
-		req, err := http.NewRequest("GET", URL, nil)
-		if err != nil {
-			return nil, err
+		repoToken := token
+		if githubAppEnabled() {
+			installationToken, err := repoInstallationToken(db, repo.Owner, repo.Name)
+			if err != nil {
+				log.Printf("Error getting installation token for %s: %v", repoKey, err)
+			} else if installationToken != "" {
+				repoToken = installationToken
+			}
 		}
 
-		if token != "" {
-			req.Header.Set("Authorization", "Bearer "+token)
+		hasCommit, err := repoHasCommitsSince(repoKey, since, repoToken)
+		if errors.Is(err, errTokenRejected) && repoToken == token {
+			// Fall back to unauthenticated access, which still works for
+			// public repos, for this and the remaining repos.
+			handleRevokedToken(db, dg, userID)
+			token = ""
+			hasCommit, err = repoHasCommitsSince(repoKey, since, "")
 		}
-		req.Header.Set("Accept", "application/vnd.github.v3+json")
-
-		res, err := http.DefaultClient.Do(req)
 		if err != nil {
-			return nil, fmt.Errorf("error making request to GitHub API: %v", err)
+			return nil, err
 		}
 
-		data, err := io.ReadAll(res.Body)
-		if err != nil {
-			return nil, fmt.Errorf("error reading response body: %v", err)
-		}
+		commitStatus[repoKey] = hasCommit
+	}
 
-		err = res.Body.Close()
-		if err != nil {
-			log.Printf("Error closing response body: %v", err)
-		}
+	return commitStatus, nil
+}
 
-		var commits []any
-		if err = json.Unmarshal(data, &commits); err != nil {
-			return nil, fmt.Errorf("error parsing json: %v", err)
-		}
+// repoHasCommitsSince reports whether the repo has a commit since the given
+// RFC 3339 time. Repos we cannot see and empty repos count as no commits.
+func repoHasCommitsSince(repoKey, since, token string) (bool, error) {
+	URL := fmt.Sprintf("https://api.github.com/repos/%s/commits?since=%s&per_page=1", repoKey, since)
 
-		commitStatus[repoKey] = len(commits) > 0
+	req, err := http.NewRequest("GET", URL, nil)
+	if err != nil {
+		return false, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
 	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
 
-	return commitStatus, nil
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("error making request to GitHub API: %v", err)
+	}
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return false, fmt.Errorf("error reading response body: %v", err)
+	}
+
+	err = res.Body.Close()
+	if err != nil {
+		log.Printf("Error closing response body: %v", err)
+	}
+
+	switch {
+	case res.StatusCode == http.StatusOK:
+	case token != "" && tokenRejected(res):
+		return false, errTokenRejected
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusConflict:
+		log.Printf("No commits available for %s, status: %d", repoKey, res.StatusCode)
+		return false, nil
+	case res.StatusCode == http.StatusForbidden:
+		// Rate limits and SSO or organization policies; try again later.
+		return false, fmt.Errorf("access to %s refused by GitHub API, status: %d", repoKey, res.StatusCode)
+	default:
+		return false, fmt.Errorf("unexpected status %d from GitHub API for %s", res.StatusCode, repoKey)
+	}
+
+	var commits []any
+	if err = json.Unmarshal(data, &commits); err != nil {
+		return false, fmt.Errorf("error parsing json: %v", err)
+	}
+	return len(commits) > 0, nil
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+func webhookURL() string {
+	return fmt.Sprintf("%s/webhook", BaseURL)
+}
+
+// ensureWebhook makes sure one hook on the repo delivers to us and records
+// its ID. A hook that another registration already created is reused, so a
+// second registrant never causes duplicate notifications.
+func ensureWebhook(db Store, token, owner, repo string) error {
+	storedID, err := db.GetRepoWebhookID(owner, repo)
+	if err != nil {
+		return err
+	}
+	if storedID != 0 {
+		exists, err := webhookExists(token, owner, repo, storedID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+		log.Printf("Webhook %d for %s/%s no longer exists on GitHub", storedID, owner, repo)
+	}
+
+	hooks, err := listWebhooks(token, owner, repo)
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		if hook.Config.URL == webhookURL() {
+			log.Printf("Adopting existing webhook %d for %s/%s", hook.ID, owner, repo)
+			return adoptWebhook(db, token, owner, repo, hook.ID, webhookURL())
+		}
+	}
+
+	return createWebhook(db, token, owner, repo, webhookURL())
+}
+
+// storedWebhookExists reports whether the repo's stored hook is confirmed to
+// still exist on GitHub. It checks with the registering user's token and
+// then with another registrant's, since either may lack access. A hook no
+// token can confirm counts as missing.
+func storedWebhookExists(db Store, token, owner, repo string, webhookID int64) bool {
+	tokens := []string{token}
+	repos, err := db.GetTrackedRepos()
+	if err != nil {
+		log.Printf("Error getting tracked repos: %v", err)
+	}
+	for _, r := range repos {
+		if strings.EqualFold(r.Owner, owner) && strings.EqualFold(r.Name, repo) {
+			tokens = append(tokens, r.Token)
+		}
+	}
+
+	for _, t := range tokens {
+		if t == "" {
+			continue
+		}
+		exists, err := webhookExists(t, owner, repo, webhookID)
+		if err != nil {
+			log.Printf("Error verifying webhook for %s/%s: %v", owner, repo, err)
+			continue
+		}
+		if exists {
+			return true
+		}
+	}
+	log.Printf("Could not confirm webhook %d for %s/%s still exists", webhookID, owner, repo)
+	return false
+}
+
+// scheduleWebhookReconciliation reconciles webhooks at startup and once a
+// day after that.
+func scheduleWebhookReconciliation(db Store) {
+	for {
+		reconcileWebhooks(db)
+		time.Sleep(24 * time.Hour)
+	}
+}
+
+// reconcileWebhooks compares the hooks pointing at BASE_URL on GitHub with
+// what we have stored: missing hooks are recreated, unrecorded ones adopted,
+// duplicates removed, and hooks left behind by failed deletes cleaned up.
+func reconcileWebhooks(db Store) {
+	repos, err := db.GetTrackedRepos()
+	if err != nil {
+		log.Printf("Error getting tracked repos: %v", err)
+		return
+	}
+
+	for _, r := range repos {
+		if r.Token == "" || (githubAppEnabled() && r.InstallationID == 0 && r.WebhookID == 0) {
+			continue
+		}
+
+		hooks, err := listWebhooks(r.Token, r.Owner, r.Name)
+		if err != nil {
+			log.Printf("Error listing webhooks for %s/%s: %v", r.Owner, r.Name, err)
+			continue
+		}
+
+		var ours []int64
+		for _, hook := range hooks {
+			if hook.Config.URL == webhookURL() {
+				ours = append(ours, hook.ID)
+			}
+		}
+
+		// The app's own webhook already delivers pushes for installed repos,
+		// so per-repo hooks would only double every notification.
+		if githubAppEnabled() && r.InstallationID != 0 {
+			for _, id := range ours {
+				log.Printf("Deleting per-repo webhook %d for %s/%s, covered by the GitHub App", id, r.Owner, r.Name)
+				if err := deleteGitHubWebhook(r.Token, r.Owner, r.Name, id); err != nil {
+					log.Printf("Error deleting webhook %d for %s/%s: %v", id, r.Owner, r.Name, err)
+				}
+			}
+			if r.WebhookID != 0 {
+				if err := db.StoreWebhookID(r.Owner, r.Name, 0, ""); err != nil {
+					log.Printf("Error clearing webhook for %s/%s: %v", r.Owner, r.Name, err)
+				}
+			}
+			continue
+		}
+
+		if len(ours) == 0 {
+			log.Printf("Recreating missing webhook for %s/%s", r.Owner, r.Name)
+			if err := createWebhook(db, r.Token, r.Owner, r.Name, webhookURL()); err != nil {
+				log.Printf("Error recreating webhook for %s/%s: %v", r.Owner, r.Name, err)
+			}
+			continue
+		}
+
+		keep := ours[0]
+		for _, id := range ours {
+			if id == r.WebhookID {
+				keep = id
+			}
+		}
+		if keep != r.WebhookID {
+			log.Printf("Adopting existing webhook %d for %s/%s", keep, r.Owner, r.Name)
+			if err := adoptWebhook(db, r.Token, r.Owner, r.Name, keep, webhookURL()); err != nil {
+				log.Printf("Error adopting webhook for %s/%s: %v", r.Owner, r.Name, err)
+				continue
+			}
+		}
+
+		for _, id := range ours {
+			if id == keep {
+				continue
+			}
+			log.Printf("Deleting duplicate webhook %d for %s/%s", id, r.Owner, r.Name)
+			if err := deleteGitHubWebhook(r.Token, r.Owner, r.Name, id); err != nil {
+				log.Printf("Error deleting duplicate webhook %d for %s/%s: %v", id, r.Owner, r.Name, err)
+			}
+		}
+	}
+
+	orphans, err := db.GetOrphanedWebhooks()
+	if err != nil {
+		log.Printf("Error getting orphaned webhooks: %v", err)
+		return
+	}
+	for _, o := range orphans {
+		token, err := db.GetGithubToken(o.UserID)
+		if err != nil || token == "" {
+			continue
+		}
+		if err := deleteGitHubWebhook(token, o.Owner, o.Name, o.WebhookID); err != nil {
+			log.Printf("Error deleting orphaned webhook %d for %s/%s: %v", o.WebhookID, o.Owner, o.Name, err)
+			continue
+		}
+		if err := db.RemoveOrphanedWebhook(o.ID); err != nil {
+			log.Printf("Error removing orphaned webhook %d: %v", o.ID, err)
+		}
+		log.Printf("Deleted orphaned webhook %d for %s/%s", o.WebhookID, o.Owner, o.Name)
+	}
+}